@@ -33,6 +33,30 @@ func (a Address) CallPass() int16 {
 	return rv & 0x7fff
 }
 
+// IsRepeated is true if this path address is marked as having been
+// repeated (e.g. WIDE1-1*).
+func (a Address) IsRepeated() bool {
+	return strings.HasSuffix(a.Call, "*") || strings.HasSuffix(a.SSID, "*")
+}
+
+// Repeated returns this address marked as having been repeated.
+func (a Address) Repeated() Address {
+	a = a.Unrepeated()
+	if a.SSID != "" {
+		a.SSID += "*"
+	} else {
+		a.Call += "*"
+	}
+	return a
+}
+
+// Unrepeated returns this address without a repeated mark.
+func (a Address) Unrepeated() Address {
+	a.Call = strings.TrimSuffix(a.Call, "*")
+	a.SSID = strings.TrimSuffix(a.SSID, "*")
+	return a
+}
+
 func parseAddresses(addrs []string) []Address {
 	var rv []Address
 
@@ -0,0 +1,196 @@
+// Package afsk implements the Bell 202 AFSK 1200 baud modem used for
+// APRS on VHF.
+package afsk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/dustin/go-aprs/ax25"
+)
+
+// Bell 202 tones and bit rate.
+const (
+	MarkFreq  = 1200
+	SpaceFreq = 2200
+	Baud      = 1200
+)
+
+// MinRate is the lowest sample rate the demodulator accepts, four
+// samples to a cycle of the mark tone.  Below it a bit is too few
+// samples to tell the tones apart.
+const MinRate = 4 * MarkFreq
+
+// ErrSampleRate is returned for audio at a sample rate below MinRate.
+var ErrSampleRate = errors.New("sample rate too low for AFSK")
+
+// dupeBits is how long, in bits, a frame decoded by one slicer
+// suppresses the same frame from the others.
+const dupeBits = 1200
+
+// A slicer turns tone energies into bits with its own balance
+// between mark and space, recovering the bit clock and finding
+// frames.
+type slicer struct {
+	spaceGain float64
+	level     byte
+	pll       int32
+	hdlc      *ax25.HDLCDecoder
+}
+
+// Demodulator finds AX.25 frames in AFSK audio.
+type Demodulator struct {
+	rate    int
+	step    int32
+	slicers []*slicer
+
+	// Reference tones, one second long since both tones have a
+	// whole number of cycles in a second.
+	markCos, markSin, spaceCos, spaceSin []float64
+	// Products of the last bit's worth of samples with the tones,
+	// and their running sums.
+	window                 [][4]float64
+	sums                   [4]float64
+	pos, phase             int
+	sampleCount, lastClean int64
+
+	recent map[string]int64
+	frames [][]byte
+	bad    int
+}
+
+// NewDemodulator gets a demodulator for audio at the given sample
+// rate, with a single slicer.
+func NewDemodulator(rate int) (*Demodulator, error) {
+	if rate < MinRate {
+		return nil, fmt.Errorf("%w: %dHz", ErrSampleRate, rate)
+	}
+	d := &Demodulator{
+		rate:     rate,
+		step:     int32(int64(1<<32) * Baud / int64(rate)),
+		markCos:  make([]float64, rate),
+		markSin:  make([]float64, rate),
+		spaceCos: make([]float64, rate),
+		spaceSin: make([]float64, rate),
+		window:   make([][4]float64, int(math.Round(float64(rate)/Baud))),
+		recent:   map[string]int64{},
+	}
+	for i := 0; i < rate; i++ {
+		m := 2 * math.Pi * MarkFreq * float64(i) / float64(rate)
+		s := 2 * math.Pi * SpaceFreq * float64(i) / float64(rate)
+		d.markCos[i], d.markSin[i] = math.Cos(m), math.Sin(m)
+		d.spaceCos[i], d.spaceSin[i] = math.Cos(s), math.Sin(s)
+	}
+	d.SetSlicers(1)
+	return d, nil
+}
+
+// SetSlicers sets how many slicers run in parallel.  Each weighs the
+// space tone differently, so together they cope with the varying
+// pre-emphasis of real transmitters at the cost of more CPU.
+func (d *Demodulator) SetSlicers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	d.slicers = nil
+	for i := 0; i < n; i++ {
+		gain := 1.0
+		if n > 1 {
+			// Spread gains from 1/3 to 3, evenly in log terms.
+			gain = math.Pow(3, 2*float64(i)/float64(n-1)-1)
+		}
+		d.slicers = append(d.slicers, &slicer{
+			spaceGain: gain,
+			hdlc:      ax25.NewHDLCDecoder(),
+		})
+	}
+}
+
+// BadFCS returns how many frames have failed their FCS check.  With
+// several slicers, one bad frame may be counted more than once.
+func (d *Demodulator) BadFCS() int {
+	return d.bad
+}
+
+// Process demodulates samples, returning any AX.25 frames (without
+// FCS) completed by them.
+func (d *Demodulator) Process(samples []int16) [][]byte {
+	d.frames = nil
+	for _, s := range samples {
+		d.sample(float64(s))
+	}
+	return d.frames
+}
+
+func (d *Demodulator) sample(x float64) {
+	p := [4]float64{
+		x * d.markCos[d.phase], x * d.markSin[d.phase],
+		x * d.spaceCos[d.phase], x * d.spaceSin[d.phase],
+	}
+	old := d.window[d.pos]
+	for i := range p {
+		d.sums[i] += p[i] - old[i]
+	}
+	d.window[d.pos] = p
+	d.pos = (d.pos + 1) % len(d.window)
+	d.phase = (d.phase + 1) % d.rate
+	d.sampleCount++
+	if d.phase == 0 {
+		// Start the sums over now and then so rounding errors
+		// don't build up.
+		d.sums = [4]float64{}
+		for _, w := range d.window {
+			for i := range w {
+				d.sums[i] += w[i]
+			}
+		}
+	}
+
+	mark := math.Hypot(d.sums[0], d.sums[1])
+	space := math.Hypot(d.sums[2], d.sums[3])
+	for _, s := range d.slicers {
+		level := byte(0)
+		if mark > space*s.spaceGain {
+			level = 1
+		}
+		if level != s.level {
+			// Pull the clock towards the transition so bits
+			// are sampled mid-way between them.
+			s.pll = int32(float64(s.pll) * 0.75)
+			s.level = level
+		}
+		prev := s.pll
+		s.pll += d.step
+		if prev > 0 && s.pll < 0 {
+			d.bit(s, level)
+		}
+	}
+}
+
+func (d *Demodulator) bit(s *slicer, level byte) {
+	f, err := s.hdlc.Bit(level)
+	if f == nil {
+		return
+	}
+	if err != nil {
+		d.bad++
+		return
+	}
+
+	dupeWindow := int64(dupeBits) * int64(d.rate) / Baud
+	if d.sampleCount-d.lastClean > dupeWindow {
+		for k, t := range d.recent {
+			if d.sampleCount-t > dupeWindow {
+				delete(d.recent, k)
+			}
+		}
+		d.lastClean = d.sampleCount
+	}
+	k := string(f)
+	if t, ok := d.recent[k]; ok && d.sampleCount-t <= dupeWindow {
+		return
+	}
+	d.recent[k] = d.sampleCount
+	d.frames = append(d.frames, f)
+}
@@ -0,0 +1,251 @@
+package afsk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/ax25"
+)
+
+var testMsgs = []string{
+	"KG6HWF>APX200,WIDE1-1,WIDE2-1:=3722.1 N/12159.1 W-Merry Christmas!",
+	"KE6AFE-2>APU25N,WR6ABD*,WIDE2-1:;TFCSCRUZ *180000z3609.90N\\12112.20WT Santa Cruz",
+	"N6RPG>APOT21,WIDE1-1:!3748.51N/12112.44W# 13.8V 25C",
+}
+
+func testFrames() [][]byte {
+	var rv [][]byte
+	for _, m := range testMsgs {
+		rv = append(rv, ax25.EncodeAPRSCommand(aprs.ParseFrame(m)))
+	}
+	return rv
+}
+
+// synth makes AFSK audio for frames, with the space tone scaled by
+// twist and gaussian noise of the given level added.
+func synth(frames [][]byte, rate int, twist, noise float64) []int16 {
+	e := ax25.NewHDLCEncoder(30, 2)
+	r := rand.New(rand.NewSource(int64(rate)))
+	var out []int16
+	phase, t := 0.0, 0.0
+	for _, f := range frames {
+		for _, level := range e.Encode(f) {
+			freq, amp := float64(MarkFreq), 8000.0
+			if level == 0 {
+				freq, amp = SpaceFreq, 8000*twist
+			}
+			for t += float64(rate) / Baud; t >= 1; t-- {
+				phase += 2 * math.Pi * freq / float64(rate)
+				v := amp*math.Sin(phase) + r.NormFloat64()*noise
+				out = append(out, int16(math.Max(-32768, math.Min(32767, v))))
+			}
+		}
+		// Some silence (or noise) between frames.
+		for i := 0; i < rate/10; i++ {
+			out = append(out, int16(r.NormFloat64()*noise))
+		}
+	}
+	return out
+}
+
+func pcmBytes(samples []int16, channels int) []byte {
+	b := &bytes.Buffer{}
+	for _, s := range samples {
+		for c := 0; c < channels; c++ {
+			binary.Write(b, binary.LittleEndian, s)
+		}
+	}
+	return b.Bytes()
+}
+
+func wavBytes(samples []int16, rate, channels int) []byte {
+	data := pcmBytes(samples, channels)
+	b := &bytes.Buffer{}
+	b.WriteString("RIFF")
+	binary.Write(b, binary.LittleEndian, uint32(4+8+16+8+3+1+8+len(data)))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), uint16(1), uint16(channels), uint32(rate),
+		uint32(rate * 2 * channels), uint16(2 * channels), uint16(16),
+	} {
+		binary.Write(b, binary.LittleEndian, v)
+	}
+	// An odd sized chunk to skip.
+	b.WriteString("LIST")
+	binary.Write(b, binary.LittleEndian, uint32(3))
+	b.WriteString("abc\x00")
+	b.WriteString("data")
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func newDemod(t testing.TB, rate int) *Demodulator {
+	d, err := NewDemodulator(rate)
+	if err != nil {
+		t.Fatalf("Error making a demodulator: %v", err)
+	}
+	return d
+}
+
+func demodAll(d *Demodulator, samples []int16) [][]byte {
+	var rv [][]byte
+	for len(samples) > 0 {
+		n := 1000
+		if n > len(samples) {
+			n = len(samples)
+		}
+		rv = append(rv, d.Process(samples[:n])...)
+		samples = samples[n:]
+	}
+	return rv
+}
+
+func TestDemodulateRates(t *testing.T) {
+	frames := testFrames()
+	for _, rate := range []int{8000, 11025, 22050, 44100, 48000} {
+		got := demodAll(newDemod(t, rate), synth(frames, rate, 1, 0))
+		if len(got) != len(frames) {
+			t.Errorf("At %vHz expected %v frames, got %v", rate, len(frames), len(got))
+			continue
+		}
+		for i := range frames {
+			if !bytes.Equal(got[i], frames[i]) {
+				t.Errorf("At %vHz frame %v was % x", rate, i, got[i])
+			}
+		}
+	}
+}
+
+func TestDemodulateLowRate(t *testing.T) {
+	for _, rate := range []int{-1, 0, 1200, 2400, MinRate - 1} {
+		if _, err := NewDemodulator(rate); !errors.Is(err, ErrSampleRate) {
+			t.Errorf("At %vHz expected ErrSampleRate, got %v", rate, err)
+		}
+	}
+	if _, err := NewDecoder(NewPCMReader(bytes.NewReader(nil), 0)); !errors.Is(err, ErrSampleRate) {
+		t.Errorf("Expected ErrSampleRate decoding at 0Hz, got %v", err)
+	}
+	// The lowest rate accepted still decodes.
+	frames := testFrames()
+	if got := demodAll(newDemod(t, MinRate), synth(frames, MinRate, 1, 0)); len(got) != len(frames) {
+		t.Errorf("At %vHz expected %v frames, got %v", MinRate, len(frames), len(got))
+	}
+}
+
+func TestSlicers(t *testing.T) {
+	var frames [][]byte
+	for i := 0; i < 10; i++ {
+		frames = append(frames, testFrames()...)
+	}
+	// Badly de-emphasized audio with plenty of noise.
+	samples := synth(frames, 22050, 0.5, 3000)
+
+	single := len(demodAll(newDemod(t, 22050), samples))
+	d := newDemod(t, 22050)
+	d.SetSlicers(9)
+	multi := demodAll(d, samples)
+	if len(multi) <= single {
+		t.Errorf("Expected more slicers to do better, got %v with one, %v with nine",
+			single, len(multi))
+	}
+	// The same frame from several slicers is only reported once.
+	if len(multi) > len(frames) {
+		t.Errorf("Expected at most %v frames, got %v", len(frames), len(multi))
+	}
+	if d.BadFCS() == 0 {
+		t.Errorf("Expected some bad frames in the noise")
+	}
+}
+
+func TestWAVDecoder(t *testing.T) {
+	samples := synth(testFrames(), 44100, 1, 100)
+	for _, channels := range []int{1, 2} {
+		pcm, err := NewWAVReader(bytes.NewReader(wavBytes(samples, 44100, channels)))
+		if err != nil {
+			t.Fatalf("Error reading WAV header: %v", err)
+		}
+		if pcm.Rate() != 44100 {
+			t.Errorf("Expected 44100Hz, got %v", pcm.Rate())
+		}
+		d, err := NewDecoder(pcm)
+		if err != nil {
+			t.Fatalf("Error making a decoder: %v", err)
+		}
+		d.SetMarkRepeated(true)
+		for _, exp := range testMsgs {
+			got, err := d.Next()
+			if err != nil {
+				t.Fatalf("Error decoding: %v", err)
+			}
+			f, _ := ax25.DecodeFrame(ax25.EncodeAPRSCommand(aprs.ParseFrame(exp)))
+			if want, _ := f.APRS(true); got.String() != want.String() {
+				t.Errorf("Expected %v, got %v", exp, got)
+			}
+		}
+		if _, err := d.Next(); err != io.EOF {
+			t.Errorf("Expected EOF, got %v", err)
+		}
+	}
+}
+
+func TestPCMDecoder(t *testing.T) {
+	samples := synth(testFrames(), 8000, 1, 0)
+	d, err := NewDecoder(NewPCMReader(bytes.NewReader(pcmBytes(samples, 1)), 8000))
+	if err != nil {
+		t.Fatalf("Error making a decoder: %v", err)
+	}
+	f, err := d.NextFrame()
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if f.Source.Call != "KG6HWF" {
+		t.Errorf("Expected a frame from KG6HWF, got %v", f)
+	}
+}
+
+func TestBadWAV(t *testing.T) {
+	tests := [][]byte{
+		[]byte("RIFX\x00\x00\x00\x00WAVE"),
+		[]byte("RIFF\x00\x00\x00\x00WAVEdata\x00\x00\x00\x00"),
+		[]byte("RIFF"),
+	}
+	wav := wavBytes(nil, 8000, 1)
+	// 8 bits per sample.
+	wav[34] = 8
+	tests = append(tests, wav)
+
+	for _, b := range tests {
+		if _, err := NewWAVReader(bytes.NewReader(b)); err == nil {
+			t.Errorf("Expected error reading %q", b)
+		}
+	}
+}
+
+func BenchmarkDemodulate(b *testing.B) {
+	var frames [][]byte
+	for i := 0; i < 10; i++ {
+		frames = append(frames, testFrames()...)
+	}
+	samples := synth(frames, 44100, 0.5, 3000)
+
+	for _, slicers := range []int{1, 3, 9} {
+		b.Run(string(rune('0'+slicers))+"slicers", func(b *testing.B) {
+			b.SetBytes(int64(2 * len(samples)))
+			decoded := 0
+			for i := 0; i < b.N; i++ {
+				d := newDemod(b, 44100)
+				d.SetSlicers(slicers)
+				decoded += len(demodAll(d, samples))
+			}
+			b.ReportMetric(float64(decoded)/float64(b.N), "frames")
+			b.ReportMetric(float64(len(frames)), "sent")
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package afsk
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/ax25"
+)
+
+// Modulator turns AX.25 frames into AFSK audio.
+type Modulator struct {
+	rate            int
+	amplitude       float64
+	txDelay, txTail time.Duration
+	phase           float64
+}
+
+// NewModulator gets a modulator producing audio at the given sample
+// rate, with a 300ms TXDELAY, a 30ms TXTAIL and half of full scale
+// amplitude.
+func NewModulator(rate int) *Modulator {
+	return &Modulator{
+		rate:      rate,
+		amplitude: 0.5,
+		txDelay:   300 * time.Millisecond,
+		txTail:    30 * time.Millisecond,
+	}
+}
+
+// SetTXDelay sets how long flags are sent before each transmission,
+// giving the receiver (and any VOX) time to settle.
+func (m *Modulator) SetTXDelay(d time.Duration) {
+	m.txDelay = d
+}
+
+// SetTXTail sets how long flags are sent after each transmission.
+func (m *Modulator) SetTXTail(d time.Duration) {
+	m.txTail = d
+}
+
+// SetAmplitude sets the tone amplitude as a fraction of full scale.
+func (m *Modulator) SetAmplitude(a float64) {
+	m.amplitude = math.Max(0, math.Min(1, a))
+}
+
+// flags returns how many flags fill a duration, at least one.
+func flags(d time.Duration) int {
+	n := int(math.Ceil(d.Seconds() * Baud / 8))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// Modulate returns the audio for a transmission of AX.25 frames
+// (without FCS), sent back to back between one TXDELAY and TXTAIL.
+func (m *Modulator) Modulate(frames ...[]byte) []int16 {
+	// One encoder for the whole transmission keeps the NRZI level
+	// going between frames.
+	e := ax25.NewHDLCEncoder(1, 1)
+	bits := e.Flags(flags(m.txDelay) - 1)
+	for _, f := range frames {
+		bits = append(bits, e.Encode(f)...)
+	}
+	bits = append(bits, e.Flags(flags(m.txTail)-1)...)
+	return m.tones(bits)
+}
+
+// ModulateAPRS returns the audio for a transmission of APRS frames.
+func (m *Modulator) ModulateAPRS(msgs ...aprs.Frame) []int16 {
+	var frames [][]byte
+	for _, msg := range msgs {
+		frames = append(frames, ax25.EncodeAPRSCommand(msg))
+	}
+	return m.Modulate(frames...)
+}
+
+// tones converts NRZI line levels to phase-continuous audio, mark
+// for 1 and space for 0.
+func (m *Modulator) tones(levels []byte) []int16 {
+	perBit := float64(m.rate) / Baud
+	out := make([]int16, 0, int(float64(len(levels))*perBit)+1)
+	amp := m.amplitude * math.MaxInt16
+	t := 0.0
+	for _, level := range levels {
+		step := 2 * math.Pi * SpaceFreq / float64(m.rate)
+		if level != 0 {
+			step = 2 * math.Pi * MarkFreq / float64(m.rate)
+		}
+		for t += perBit; t >= 0.5; t-- {
+			out = append(out, int16(math.Round(amp*math.Sin(m.phase))))
+			m.phase = math.Mod(m.phase+step, 2*math.Pi)
+		}
+	}
+	return out
+}
+
+// WritePCM writes samples as signed 16-bit little-endian PCM.
+func WritePCM(w io.Writer, samples []int16) error {
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+// WriteWAV writes samples as a mono 16-bit PCM WAV file.
+func WriteWAV(w io.Writer, rate int, samples []int16) error {
+	size := uint32(2 * len(samples))
+	hdr := []interface{}{
+		[]byte("RIFF"), 36 + size, []byte("WAVE"),
+		[]byte("fmt "), uint32(16),
+		uint16(1), // PCM
+		uint16(1), // mono
+		uint32(rate), uint32(2 * rate),
+		uint16(2),  // bytes per sample frame
+		uint16(16), // bits per sample
+		[]byte("data"), size,
+	}
+	for _, v := range hdr {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return WritePCM(w, samples)
+}
@@ -0,0 +1,92 @@
+package afsk
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+func TestModulateRoundTrip(t *testing.T) {
+	frames := testFrames()
+	for _, rate := range []int{8000, 11025, 22050, 44100, 48000} {
+		samples := NewModulator(rate).Modulate(frames...)
+		got := demodAll(newDemod(t, rate), samples)
+		if len(got) != len(frames) {
+			t.Errorf("At %vHz expected %v frames, got %v", rate, len(frames), len(got))
+			continue
+		}
+		for i := range frames {
+			if !bytes.Equal(got[i], frames[i]) {
+				t.Errorf("At %vHz frame %v was % x", rate, i, got[i])
+			}
+		}
+	}
+}
+
+func TestModulateTiming(t *testing.T) {
+	m := NewModulator(48000)
+	m.SetTXDelay(500 * time.Millisecond)
+	m.SetTXTail(0)
+	long := len(m.Modulate(testFrames()[0]))
+	m.SetTXDelay(0)
+	short := len(m.Modulate(testFrames()[0]))
+
+	// 75 flags of TXDELAY rather than one, at 40 samples a bit.
+	if exp := 74 * 8 * 40; long-short != exp {
+		t.Errorf("Expected TXDELAY to add %v samples, added %v", exp, long-short)
+	}
+}
+
+func TestModulatePhaseContinuous(t *testing.T) {
+	m := NewModulator(44100)
+	m.SetAmplitude(1)
+	samples := m.Modulate(testFrames()...)
+	// The biggest step between samples a continuous space tone can
+	// make.
+	maxStep := math.MaxInt16 * 2 * math.Pi * SpaceFreq / 44100 * 1.01
+	for i := 1; i < len(samples); i++ {
+		if d := math.Abs(float64(samples[i]) - float64(samples[i-1])); d > maxStep {
+			t.Fatalf("Discontinuity of %v at sample %v", d, i)
+		}
+	}
+}
+
+func TestWriteWAV(t *testing.T) {
+	msg := aprs.ParseFrame(testMsgs[0])
+	b := &bytes.Buffer{}
+	if err := WriteWAV(b, 22050, NewModulator(22050).ModulateAPRS(msg)); err != nil {
+		t.Fatalf("Error writing WAV: %v", err)
+	}
+
+	pcm, err := NewWAVReader(b)
+	if err != nil {
+		t.Fatalf("Error reading WAV: %v", err)
+	}
+	if pcm.Rate() != 22050 {
+		t.Errorf("Expected 22050Hz, got %v", pcm.Rate())
+	}
+	d, err := NewDecoder(pcm)
+	if err != nil {
+		t.Fatalf("Error making a decoder: %v", err)
+	}
+	got, err := d.Next()
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if string(got.Body) != string(msg.Body) || got.Source.Call != msg.Source.Call {
+		t.Errorf("Expected %v, got %v", msg, got)
+	}
+}
+
+func TestWritePCM(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := WritePCM(b, []int16{1, -2, 0x1234}); err != nil {
+		t.Fatalf("Error writing PCM: %v", err)
+	}
+	if exp := []byte{1, 0, 0xfe, 0xff, 0x34, 0x12}; !bytes.Equal(b.Bytes(), exp) {
+		t.Errorf("Expected % x, got % x", exp, b.Bytes())
+	}
+}
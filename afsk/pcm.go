@@ -0,0 +1,180 @@
+package afsk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/ax25"
+)
+
+var errNotWAV = errors.New("not a WAV file")
+
+// PCMReader reads signed 16-bit little-endian PCM audio, taking the
+// first channel of multi-channel audio.
+type PCMReader struct {
+	r        *bufio.Reader
+	channels int
+	rate     int
+	buf      []byte
+}
+
+// NewPCMReader gets a reader for raw mono PCM at the given sample
+// rate.
+func NewPCMReader(r io.Reader, rate int) *PCMReader {
+	return &PCMReader{r: bufio.NewReader(r), channels: 1, rate: rate}
+}
+
+// NewWAVReader reads a WAV header and gets a reader for the audio
+// that follows.  Only 16-bit PCM is supported.
+func NewWAVReader(r io.Reader) (*PCMReader, error) {
+	br := bufio.NewReader(r)
+	var riff [12]byte
+	if _, err := io.ReadFull(br, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errNotWAV
+	}
+
+	p := &PCMReader{r: br}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch string(hdr[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("%w: short fmt chunk", errNotWAV)
+			}
+			var fmtc [16]byte
+			if _, err := io.ReadFull(br, fmtc[:]); err != nil {
+				return nil, err
+			}
+			format := binary.LittleEndian.Uint16(fmtc[0:])
+			p.channels = int(binary.LittleEndian.Uint16(fmtc[2:]))
+			p.rate = int(binary.LittleEndian.Uint32(fmtc[4:]))
+			bits := binary.LittleEndian.Uint16(fmtc[14:])
+			// 0xfffe is WAVE_FORMAT_EXTENSIBLE, used for PCM
+			// with more than two channels.
+			if (format != 1 && format != 0xfffe) || bits != 16 {
+				return nil, fmt.Errorf("unsupported WAV format %d with %d bits per sample", format, bits)
+			}
+			if p.channels < 1 || p.rate < 1 {
+				return nil, fmt.Errorf("%w: %d channels at %dHz", errNotWAV, p.channels, p.rate)
+			}
+			size -= 16
+		case "data":
+			if p.rate == 0 {
+				return nil, fmt.Errorf("%w: data before fmt chunk", errNotWAV)
+			}
+			return p, nil
+		}
+		// Skip the rest of the chunk, and its padding.
+		if _, err := io.CopyN(ioutil.Discard, br, size+size%2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Rate returns the sample rate.
+func (p *PCMReader) Rate() int {
+	return p.rate
+}
+
+// Read reads samples, returning how many were read.
+func (p *PCMReader) Read(samples []int16) (int, error) {
+	frame := 2 * p.channels
+	if need := len(samples) * frame; len(p.buf) < need {
+		p.buf = make([]byte, need)
+	}
+	n, err := io.ReadFull(p.r, p.buf[:len(samples)*frame])
+	n /= frame
+	for i := 0; i < n; i++ {
+		samples[i] = int16(binary.LittleEndian.Uint16(p.buf[i*frame:]))
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+		if n == 0 {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// Decoder is an AX.25 decoder reading from AFSK audio.
+type Decoder struct {
+	pcm          *PCMReader
+	demod        *Demodulator
+	samples      []int16
+	pending      [][]byte
+	markRepeated bool
+}
+
+// NewDecoder gets a decoder for audio from this PCM reader.
+func NewDecoder(pcm *PCMReader) (*Decoder, error) {
+	demod, err := NewDemodulator(pcm.Rate())
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{
+		pcm:     pcm,
+		demod:   demod,
+		samples: make([]int16, 4096),
+	}, nil
+}
+
+// SetSlicers sets how many slicers the demodulator runs.
+func (d *Decoder) SetSlicers(n int) {
+	d.demod.SetSlicers(n)
+}
+
+// SetMarkRepeated sets whether the last path address with its
+// has-been-repeated bit set is marked with a *, as in TNC2 format.
+func (d *Decoder) SetMarkRepeated(to bool) {
+	d.markRepeated = to
+}
+
+// BadFCS returns how many frames have failed their FCS check.
+func (d *Decoder) BadFCS() int {
+	return d.demod.BadFCS()
+}
+
+// NextFrame gets the next AX.25 frame of any kind, skipping those
+// that can't be decoded.
+func (d *Decoder) NextFrame() (ax25.Frame, error) {
+	for {
+		for len(d.pending) > 0 {
+			b := d.pending[0]
+			d.pending = d.pending[1:]
+			if f, err := ax25.DecodeFrame(b); err == nil {
+				return f, nil
+			}
+		}
+		n, err := d.pcm.Read(d.samples)
+		d.pending = d.demod.Process(d.samples[:n])
+		if err != nil && len(d.pending) == 0 {
+			return ax25.Frame{}, err
+		}
+	}
+}
+
+// Next gets the next APRS message, skipping frames that can't carry
+// APRS.
+func (d *Decoder) Next() (aprs.Frame, error) {
+	for {
+		f, err := d.NextFrame()
+		if err != nil {
+			return aprs.Frame{}, err
+		}
+		if f.IsAPRS() {
+			return f.APRS(d.markRepeated)
+		}
+	}
+}
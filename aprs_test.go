@@ -87,6 +87,33 @@ func TestCallPass(t *testing.T) {
 	}
 }
 
+func TestRepeated(t *testing.T) {
+	tests := []struct {
+		in       string
+		repeated bool
+		marked   string
+		unmarked string
+	}{
+		{"WIDE1-1", false, "WIDE1-1*", "WIDE1-1"},
+		{"WIDE1-1*", true, "WIDE1-1*", "WIDE1-1"},
+		{"WR6ABD", false, "WR6ABD*", "WR6ABD"},
+		{"WR6ABD*", true, "WR6ABD*", "WR6ABD"},
+	}
+
+	for _, test := range tests {
+		a := AddressFromString(test.in)
+		if a.IsRepeated() != test.repeated {
+			t.Errorf("%v.IsRepeated() = %v, want %v", a, a.IsRepeated(), test.repeated)
+		}
+		if s := a.Repeated().String(); s != test.marked {
+			t.Errorf("%v.Repeated() = %v, want %v", a, s, test.marked)
+		}
+		if s := a.Unrepeated().String(); s != test.unmarked {
+			t.Errorf("%v.Unrepeated() = %v, want %v", a, s, test.unmarked)
+		}
+	}
+}
+
 func TestAPRS(t *testing.T) {
 	v := ParseFrame(christmasMsg)
 	assert(t, "Source", v.Source.String(), "KG6HWF")
@@ -2,11 +2,14 @@
 package aprsis
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/textproto"
+	"sync"
 
 	"github.com/dustin/go-aprs"
 )
@@ -17,8 +20,20 @@ var errInvalidMsg = errors.New("invalid message")
 // An APRSIS connection.
 type APRSIS struct {
 	conn        *textproto.Conn
+	nc          net.Conn
+	addr        string
 	rawLog      io.Writer
 	infoHandler InfoHandler
+
+	eventHandler EventHandler
+	pass         string
+	sawLine      bool
+
+	wmu sync.Mutex
+
+	mu     sync.Mutex
+	status LoginStatus
+	server string
 }
 
 // InfoHandler is a handler for incoming info messages.
@@ -34,7 +49,11 @@ func (d dumbInfoHandlerT) Info(msg string) {
 var dumbInfoHandler dumbInfoHandlerT
 
 // Next returns the next APRS message from this connection.
-func (a *APRSIS) Next() (rv aprs.Frame, err error) {
+func (a *APRSIS) Next() (aprs.Frame, error) {
+	return a.NextContext(context.Background())
+}
+
+func (a *APRSIS) next() (rv aprs.Frame, err error) {
 	var line string
 	for err == nil || err == errEmptyMsg {
 		line, err = a.conn.ReadLine()
@@ -43,9 +62,14 @@ func (a *APRSIS) Next() (rv aprs.Frame, err error) {
 		}
 
 		fmt.Fprintf(a.rawLog, "%s\n", line)
+		first := !a.sawLine
+		a.sawLine = true
 
 		if len(line) > 0 && line[0] == '#' {
 			a.infoHandler.Info(line)
+			if err = a.handleComment(line, first); err != nil {
+				return
+			}
 		} else if len(line) > 0 {
 			rv = aprs.ParseFrame(line)
 			if !rv.IsValid() {
@@ -58,6 +82,40 @@ func (a *APRSIS) Next() (rv aprs.Frame, err error) {
 	return rv, errEmptyMsg
 }
 
+// handleComment delivers a server comment as an Event, noting the
+// login status if it's a login response.
+func (a *APRSIS) handleComment(line string, first bool) error {
+	e := ParseEvent(line)
+	if first {
+		e = e.asBanner()
+	}
+	if e.Type == LoginEvent {
+		a.mu.Lock()
+		a.status, a.server = e.Status, e.Server
+		a.mu.Unlock()
+	}
+	a.eventHandler.Event(e)
+	if e.Type == LoginEvent && e.Status == LoginUnverified && a.pass != "-1" {
+		return ErrUnverified
+	}
+	return nil
+}
+
+// LoginStatus returns how the server responded to our login.
+func (a *APRSIS) LoginStatus() LoginStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+// ServerName returns the name the server gave in its login
+// response.
+func (a *APRSIS) ServerName() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.server
+}
+
 // SetRawLog sets a writer that will receive all raw APRS-IS messages.
 func (a *APRSIS) SetRawLog(to io.Writer) {
 	a.rawLog = to
@@ -68,18 +126,34 @@ func (a *APRSIS) SetInfoHandler(to InfoHandler) {
 	a.infoHandler = to
 }
 
+// SetEventHandler sets a handler for parsed APRS-IS comments.
+func (a *APRSIS) SetEventHandler(to EventHandler) {
+	a.eventHandler = to
+}
+
 // Dial an APRS-IS service.
 func Dial(prot, addr string) (rv *APRSIS, err error) {
-	var conn *textproto.Conn
-	conn, err = textproto.Dial(prot, addr)
+	return DialContext(context.Background(), prot, addr)
+}
+
+// DialContext dials an APRS-IS service, giving up if the context is
+// done before the connection is established.
+func DialContext(ctx context.Context, prot, addr string) (*APRSIS, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, prot, addr)
 	if err != nil {
-		return
+		return nil, err
 	}
+	return newAPRSIS(nc), nil
+}
 
-	return &APRSIS{conn: conn,
-		rawLog:      ioutil.Discard,
-		infoHandler: dumbInfoHandler,
-	}, nil
+func newAPRSIS(nc net.Conn) *APRSIS {
+	return &APRSIS{conn: textproto.NewConn(nc),
+		nc:           nc,
+		rawLog:       ioutil.Discard,
+		infoHandler:  dumbInfoHandler,
+		eventHandler: dumbEventHandler,
+	}
 }
 
 // Close disconnects from the underlying textproto conn.
@@ -89,18 +163,14 @@ func (a *APRSIS) Close() error {
 
 // Send raw APRS packet using underlying textproto conn.
 func (a *APRSIS) SendRawPacket(format string, args ...interface{}) error {
+	a.wmu.Lock()
+	defer a.wmu.Unlock()
 	return a.conn.PrintfLine(format, args...)
 }
 
-// Auth authenticates and optionally set a filter.
+// Auth authenticates and optionally set a filter.  If the pass
+// isn't -1 and the server responds that the login is unverified,
+// Next returns ErrUnverified.
 func (a *APRSIS) Auth(user, pass, filter string) error {
-	if filter != "" {
-		filter = fmt.Sprintf(" filter %s", filter)
-	}
-
-	return a.SendRawPacket("user %s pass %s vers goaprs 0.1%s",
-		user, pass, filter)
+	return a.AuthContext(context.Background(), user, pass, filter)
 }
-
-
-
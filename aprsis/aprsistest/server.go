@@ -0,0 +1,299 @@
+// Package aprsistest provides an in-process APRS-IS server for
+// testing APRS-IS clients.
+package aprsistest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+// DefaultTimeout is how long Accept and Conn.Next wait by default.
+const DefaultTimeout = 5 * time.Second
+
+// ErrTimeout is returned when waiting for a client times out.
+var ErrTimeout = errors.New("timed out")
+
+// A Server is an APRS-IS server listening on a local port.  It
+// greets each client with a banner, answers its login, then hands
+// the connection to the test via Accept.
+type Server struct {
+	// Addr is the host:port the server is listening on.
+	Addr string
+	// Name is the server name given in login responses.
+	Name string
+	// Banner is the comment sent when a client connects.
+	Banner string
+
+	ln       net.Listener
+	accepted chan *Conn
+
+	mu    sync.Mutex
+	delay time.Duration
+	conns []*Conn
+}
+
+// NewServer starts a server on a local port.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("aprsistest: failed to listen: %v", err))
+	}
+	return newServer(ln)
+}
+
+// NewTLSServer starts a server on a local port that speaks TLS with
+// the given configuration.
+func NewTLSServer(config *tls.Config) *Server {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		panic(fmt.Sprintf("aprsistest: failed to listen: %v", err))
+	}
+	return newServer(ln)
+}
+
+func newServer(ln net.Listener) *Server {
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		Name:     "APRSISTEST",
+		Banner:   "aprsistest 1.0",
+		ln:       ln,
+		accepted: make(chan *Conn, 10),
+	}
+	go s.serve()
+	return s
+}
+
+// SetDelay makes the server wait this long before sending each line,
+// to simulate a slow server.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+func (s *Server) lineDelay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delay
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(nc)
+	}
+}
+
+func (s *Server) handle(nc net.Conn) {
+	c := &Conn{s: s, nc: nc, lines: make(chan string, 1000)}
+	r := textproto.NewReader(bufio.NewReader(nc))
+	if err := c.Send("# " + s.Banner); err != nil {
+		nc.Close()
+		return
+	}
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			nc.Close()
+			return
+		}
+		if line != "" && line[0] != '#' {
+			c.parseLogin(line)
+			break
+		}
+	}
+
+	status := "unverified"
+	if c.Verified {
+		status = "verified"
+	}
+	if err := c.Send(fmt.Sprintf("# logresp %s %s, server %s", c.User, status, s.Name)); err != nil {
+		nc.Close()
+		return
+	}
+
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+	s.accepted <- c
+
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			close(c.lines)
+			return
+		}
+		c.lines <- line
+	}
+}
+
+// Accept waits for the next client to log in.
+func (s *Server) Accept() (*Conn, error) {
+	select {
+	case c := <-s.accepted:
+		return c, nil
+	case <-time.After(DefaultTimeout):
+		return nil, ErrTimeout
+	}
+}
+
+// Close stops the server and disconnects all clients.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Disconnect()
+	}
+	return err
+}
+
+// A Conn is a client logged in to a Server.
+type Conn struct {
+	// Login is the login line the client sent.
+	Login string
+	// User, Pass, Software and Filter are parsed from the login.
+	User, Pass, Software, Filter string
+	// Verified is true if Pass is the passcode for User.
+	Verified bool
+
+	s     *Server
+	nc    net.Conn
+	lines chan string
+	wmu   sync.Mutex
+}
+
+func (c *Conn) parseLogin(line string) {
+	c.Login = line
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "user":
+			c.User = fields[i+1]
+		case "pass":
+			c.Pass = fields[i+1]
+		case "vers":
+			c.Software = fields[i+1]
+			if i+2 < len(fields) && fields[i+2] != "filter" {
+				c.Software += " " + fields[i+2]
+			}
+		case "filter":
+			c.Filter = strings.Join(fields[i+1:], " ")
+			i = len(fields)
+		}
+	}
+	call := aprs.AddressFromString(c.User)
+	c.Verified = call.Call != "" && c.Pass == strconv.Itoa(int(call.CallPass()))
+}
+
+// Send sends lines to the client.
+func (c *Conn) Send(lines ...string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for _, l := range lines {
+		if d := c.s.lineDelay(); d > 0 {
+			time.Sleep(d)
+		}
+		if _, err := fmt.Fprintf(c.nc, "%s\r\n", l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendFrames sends frames to the client.
+func (c *Conn) SendFrames(frames ...aprs.Frame) error {
+	for _, f := range frames {
+		if err := c.Send(f.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Next returns the next line the client sent after logging in.
+func (c *Conn) Next() (string, error) {
+	select {
+	case l, ok := <-c.lines:
+		if !ok {
+			return "", io.EOF
+		}
+		return l, nil
+	case <-time.After(DefaultTimeout):
+		return "", ErrTimeout
+	}
+}
+
+// TLSState returns the state of a TLS connection, or false if the
+// connection isn't TLS.
+func (c *Conn) TLSState() (tls.ConnectionState, bool) {
+	if tc, ok := c.nc.(*tls.Conn); ok {
+		return tc.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// Disconnect drops the client's connection.
+func (c *Conn) Disconnect() error {
+	return c.nc.Close()
+}
+
+// ReadLog reads the packets from a log file, such as those in this
+// repository's logs directory.  Files ending in .gz are
+// decompressed.  Packets logged by an igate as
+// "IGATE RF->NET(N):packet" are unwrapped, and comments and other
+// log lines are skipped.
+func ReadLog(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var rv []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := s.Text()
+		if strings.HasPrefix(l, "IGATE ") {
+			if i := strings.Index(l, "):"); i > 0 {
+				l = l[i+2:]
+			}
+		}
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		fr := aprs.ParseFrame(l)
+		if !fr.IsValid() || fr.Source.Call == "" ||
+			strings.ContainsAny(fr.Source.Call, " :") || len(fr.Body) == 0 {
+			continue
+		}
+		rv = append(rv, l)
+	}
+	return rv, s.Err()
+}
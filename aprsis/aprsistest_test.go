@@ -0,0 +1,89 @@
+package aprsis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/aprsis/aprsistest"
+)
+
+type nextResult struct {
+	f   aprs.Frame
+	err error
+}
+
+// goNext calls Next in the background, since it blocks until the
+// test server sends something.
+func goNext(c *Client) chan nextResult {
+	ch := make(chan nextResult, 1)
+	go func() {
+		f, err := c.Next()
+		ch <- nextResult{f, err}
+	}()
+	return ch
+}
+
+func expectFrame(t *testing.T, ch chan nextResult, body string) {
+	select {
+	case r := <-ch:
+		if r.err != nil || string(r.f.Body) != body {
+			t.Fatalf("Expected %q, got %v/%v", body, r.f, r.err)
+		}
+	case <-time.After(aprsistest.DefaultTimeout):
+		t.Fatalf("Timed out waiting for %q", body)
+	}
+}
+
+func accept(t *testing.T, s *aprsistest.Server) *aprsistest.Conn {
+	conn, err := s.Accept()
+	if err != nil {
+		t.Fatalf("Error waiting for login: %v", err)
+	}
+	return conn
+}
+
+func TestClientSlowServer(t *testing.T) {
+	s := aprsistest.NewServer()
+	defer s.Close()
+	s.SetDelay(20 * time.Millisecond)
+
+	c := NewClient([]string{s.Addr}, "KG6HWF", "-1", "")
+	c.SetWatchdog(time.Second)
+	defer c.Close()
+
+	res := goNext(c)
+	accept(t, s).Send("KG6HWF>APRS:>slow")
+	expectFrame(t, res, ">slow")
+}
+
+func TestClientLog(t *testing.T) {
+	packets, err := aprsistest.ReadLog("../logs/igate.log.gz")
+	if err != nil {
+		t.Fatalf("Error reading log: %v", err)
+	}
+	if len(packets) < 1000 {
+		t.Fatalf("Expected lots of packets, got %v", len(packets))
+	}
+
+	s := aprsistest.NewServer()
+	defer s.Close()
+	c := NewClient([]string{s.Addr}, "KG6HWF", "-1", "")
+	defer c.Close()
+
+	res := goNext(c)
+	conn := accept(t, s)
+	go conn.Send(packets...)
+	for i, p := range packets {
+		r := <-res
+		if r.err != nil {
+			t.Fatalf("Error reading packet %v: %v", i, r.err)
+		}
+		if got := r.f.String(); got != p {
+			t.Fatalf("Expected packet %v to be %q, got %q", i, p, got)
+		}
+		if i < len(packets)-1 {
+			res = goNext(c)
+		}
+	}
+}
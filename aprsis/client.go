@@ -0,0 +1,321 @@
+package aprsis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+// ErrClosed is returned from a Client that has been closed.
+var ErrClosed = errors.New("client closed")
+
+// Default reconnection backoff limits.
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// State is the connection state of a Client.
+type State int
+
+const (
+	// Disconnected means there's no connection to a server.
+	Disconnected State = iota
+	// Connecting means a connection is being established.
+	Connecting
+	// Connected means the client is connected and logged in.
+	Connected
+)
+
+var stateNames = map[State]string{
+	Disconnected: "disconnected",
+	Connecting:   "connecting",
+	Connected:    "connected",
+}
+
+func (s State) String() string {
+	if n, ok := stateNames[s]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// StateHandler is a handler for Client connection state changes.
+// server is the address involved and err is set when the change was
+// caused by an error.
+type StateHandler interface {
+	StateChanged(s State, server string, err error)
+}
+
+type dumbStateHandlerT struct{}
+
+func (d dumbStateHandlerT) StateChanged(State, string, error) {
+}
+
+var dumbStateHandler dumbStateHandlerT
+
+// A Client is a connection to APRS-IS that reconnects as needed,
+// rotating through a list of servers with jittered exponential
+// backoff and logging in again after each reconnect.
+type Client struct {
+	servers    []string
+	user, pass string
+
+	minBackoff, maxBackoff time.Duration
+	watchdog               time.Duration
+	rawLog                 io.Writer
+	infoHandler            InfoHandler
+	eventHandler           EventHandler
+	stateHandler           StateHandler
+
+	// dial is replaceable for testing.
+	dial func(ctx context.Context, prot, addr string) (*APRSIS, error)
+
+	mu       sync.Mutex
+	filter   string
+	current  *APRSIS
+	next     int
+	attempts int
+	closed   chan bool
+}
+
+// NewClient creates a client that will log in to one of the given
+// servers (host:port, e.g. rotate.aprs2.net:14580) with the given
+// credentials and filter.  No connection is made until Next is
+// called.
+func NewClient(servers []string, user, pass, filter string) *Client {
+	return &Client{
+		servers:      servers,
+		user:         user,
+		pass:         pass,
+		filter:       filter,
+		minBackoff:   DefaultMinBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+		rawLog:       ioutil.Discard,
+		infoHandler:  dumbInfoHandler,
+		eventHandler: dumbEventHandler,
+		stateHandler: dumbStateHandler,
+		dial:         DialContext,
+		closed:       make(chan bool),
+	}
+}
+
+// SetBackoff sets the shortest and longest delays between
+// reconnection attempts.
+func (c *Client) SetBackoff(min, max time.Duration) {
+	c.minBackoff, c.maxBackoff = min, max
+}
+
+// SetWatchdog sets how long a connection may go without receiving a
+// frame before it's dropped and reconnected.  0 disables the
+// watchdog.
+func (c *Client) SetWatchdog(d time.Duration) {
+	c.watchdog = d
+}
+
+// SetRawLog sets a writer that will receive all raw APRS-IS messages.
+func (c *Client) SetRawLog(to io.Writer) {
+	c.rawLog = to
+}
+
+// SetInfoHandler set a handler for APRS-IS Info messages.
+func (c *Client) SetInfoHandler(to InfoHandler) {
+	c.infoHandler = to
+}
+
+// SetEventHandler sets a handler for parsed APRS-IS comments.
+func (c *Client) SetEventHandler(to EventHandler) {
+	c.eventHandler = to
+}
+
+// SetStateHandler sets a handler for connection state changes.
+func (c *Client) SetStateHandler(to StateHandler) {
+	c.stateHandler = to
+}
+
+// backoff returns how long to wait before the given reconnection
+// attempt (0 being the first).
+func (c *Client) backoff(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+	d := c.minBackoff
+	for i := 1; i < attempt && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	// Jitter between half and all of the delay.
+	if d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	return d
+}
+
+// connect returns the current connection, establishing one if
+// needed.
+func (c *Client) connect(ctx context.Context) (*APRSIS, error) {
+	for {
+		c.mu.Lock()
+		if c.current != nil {
+			defer c.mu.Unlock()
+			return c.current, nil
+		}
+		if len(c.servers) == 0 {
+			c.mu.Unlock()
+			return nil, errors.New("no servers")
+		}
+		wait := c.backoff(c.attempts)
+		c.attempts++
+		addr := c.servers[c.next%len(c.servers)]
+		c.next++
+		c.mu.Unlock()
+
+		select {
+		case <-c.closed:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		c.stateHandler.StateChanged(Connecting, addr, nil)
+		is, err := c.login(ctx, addr)
+		if err != nil {
+			c.stateHandler.StateChanged(Disconnected, addr, err)
+			if cerr := ctx.Err(); cerr != nil {
+				return nil, cerr
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		select {
+		case <-c.closed:
+			c.mu.Unlock()
+			is.Close()
+			return nil, ErrClosed
+		default:
+		}
+		c.current = is
+		c.mu.Unlock()
+		c.stateHandler.StateChanged(Connected, addr, nil)
+	}
+}
+
+func (c *Client) login(ctx context.Context, addr string) (*APRSIS, error) {
+	is, err := c.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	is.addr = addr
+	is.SetRawLog(c.rawLog)
+	is.SetInfoHandler(c.infoHandler)
+	is.SetEventHandler(c.eventHandler)
+
+	c.mu.Lock()
+	filter := c.filter
+	c.mu.Unlock()
+	if err := is.AuthContext(ctx, c.user, c.pass, filter); err != nil {
+		is.Close()
+		return nil, err
+	}
+	return is, nil
+}
+
+// disconnect drops the given connection if it's still current.
+func (c *Client) disconnect(is *APRSIS, err error) {
+	c.mu.Lock()
+	if c.current != is {
+		c.mu.Unlock()
+		return
+	}
+	c.current = nil
+	c.mu.Unlock()
+	is.Close()
+	c.stateHandler.StateChanged(Disconnected, is.addr, err)
+}
+
+// LoginStatus returns how the current server responded to our
+// login, or LoginPending if there's no connection.
+func (c *Client) LoginStatus() LoginStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return LoginPending
+	}
+	return c.current.LoginStatus()
+}
+
+// Next returns the next APRS message, connecting or reconnecting as
+// necessary.  It returns ErrUnverified if the server rejects the
+// passcode (the connection is kept for receiving, so Next may be
+// called again), and ErrClosed once the client is closed.
+func (c *Client) Next() (aprs.Frame, error) {
+	return c.NextContext(context.Background())
+}
+
+// NextContext is Next, giving up if the context is done first.
+func (c *Client) NextContext(ctx context.Context) (aprs.Frame, error) {
+	for {
+		is, err := c.connect(ctx)
+		if err != nil {
+			return aprs.Frame{}, err
+		}
+
+		var wd *time.Timer
+		if c.watchdog > 0 {
+			wd = time.AfterFunc(c.watchdog, func() {
+				c.disconnect(is, errors.New("watchdog timeout"))
+			})
+		}
+		f, err := is.NextContext(ctx)
+		if wd != nil {
+			wd.Stop()
+		}
+
+		switch err {
+		case nil:
+			c.mu.Lock()
+			c.attempts = 0
+			c.mu.Unlock()
+			return f, nil
+		case errInvalidMsg:
+			continue
+		case ErrUnverified:
+			return aprs.Frame{}, err
+		case context.Canceled, context.DeadlineExceeded:
+			// The aborted connection was closed, so the next
+			// call reconnects.
+			c.disconnect(is, err)
+			return aprs.Frame{}, err
+		}
+		c.disconnect(is, err)
+	}
+}
+
+// Close disconnects the client and stops it from reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		return ErrClosed
+	default:
+	}
+	close(c.closed)
+	is := c.current
+	c.mu.Unlock()
+
+	if is != nil {
+		c.disconnect(is, ErrClosed)
+	}
+	return nil
+}
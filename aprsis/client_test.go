@@ -0,0 +1,163 @@
+package aprsis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+// serveOnce accepts one connection, reads the login and sends the
+// given lines, then hands the login line back.  The connection is
+// left open until keepOpen is closed.
+func serveOnce(t *testing.T, ln net.Listener, lines []string, keepOpen chan bool) chan string {
+	logins := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := textproto.NewReader(bufio.NewReader(conn))
+		login, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		logins <- login
+		for _, l := range lines {
+			fmt.Fprintf(conn, "%s\r\n", l)
+		}
+		<-keepOpen
+	}()
+	return logins
+}
+
+func expectLogin(t *testing.T, logins chan string, exp string) {
+	select {
+	case got := <-logins:
+		if got != exp {
+			t.Fatalf("Expected login %q, got %q", exp, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for login")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := NewClient(nil, "", "", "")
+	c.SetBackoff(time.Second, 10*time.Second)
+	if d := c.backoff(0); d != 0 {
+		t.Errorf("Expected first attempt to be immediate, got %v", d)
+	}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{10, 10 * time.Second},
+	}
+	for _, test := range tests {
+		d := c.backoff(test.attempt)
+		if d < test.max/2 || d > test.max {
+			t.Errorf("backoff(%v) = %v, want between %v and %v",
+				test.attempt, d, test.max/2, test.max)
+		}
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	// A server that isn't listening is skipped.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	dead.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer ln.Close()
+
+	c := NewClient([]string{dead.Addr().String(), ln.Addr().String()},
+		"KG6HWF", "22955", "p/KG")
+	c.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	defer c.Close()
+
+	states := make(chan State, 100)
+	c.SetStateHandler(stateRecorder(states))
+
+	keep := make(chan bool)
+	logins := serveOnce(t, ln, []string{"# server", "KG6HWF>APRS:>one"}, keep)
+	f, err := c.Next()
+	if err != nil || string(f.Body) != ">one" {
+		t.Fatalf("Expected first frame, got %v/%v", f, err)
+	}
+	expectLogin(t, logins, "user KG6HWF pass 22955 vers goaprs 0.1 filter p/KG")
+
+	// Dropping the connection logs in again and carries on.
+	logins = serveOnce(t, ln, []string{"KG6HWF>APRS:>two"}, keep)
+	close(keep)
+	f, err = c.Next()
+	if err != nil || string(f.Body) != ">two" {
+		t.Fatalf("Expected second frame, got %v/%v", f, err)
+	}
+	expectLogin(t, logins, "user KG6HWF pass 22955 vers goaprs 0.1 filter p/KG")
+
+	seen := map[State]bool{}
+	for len(states) > 0 {
+		seen[<-states] = true
+	}
+	for _, s := range []State{Connecting, Connected, Disconnected} {
+		if !seen[s] {
+			t.Errorf("Expected a %v state change", s)
+		}
+	}
+}
+
+func TestClientWatchdog(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer ln.Close()
+
+	c := NewClient([]string{ln.Addr().String()}, "KG6HWF", "-1", "")
+	c.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	c.SetWatchdog(50 * time.Millisecond)
+	defer c.Close()
+
+	// The first server goes quiet, so the watchdog reconnects.
+	keep := make(chan bool)
+	defer close(keep)
+	serveOnce(t, ln, nil, keep)
+	serveOnce(t, ln, []string{"KG6HWF>APRS:>awake"}, keep)
+	f, err := c.Next()
+	if err != nil || string(f.Body) != ">awake" {
+		t.Fatalf("Expected frame after watchdog, got %v/%v", f, err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	c := NewClient([]string{"127.0.0.1:1"}, "KG6HWF", "-1", "")
+	c.SetBackoff(time.Hour, time.Hour)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Close()
+	}()
+	if _, err := c.Next(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+type stateRecorder chan State
+
+func (s stateRecorder) StateChanged(st State, server string, err error) {
+	s <- st
+}
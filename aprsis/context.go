@@ -0,0 +1,161 @@
+package aprsis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+// aLongTimeAgo is a deadline in the past, used to abort blocked
+// reads and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// withContext runs f, aborting any I/O it's blocked on if the
+// context is done first.  Aborted I/O may have read or written part
+// of a line, so the connection is closed rather than reused.
+func (a *APRSIS) withContext(ctx context.Context, f func() error) error {
+	if ctx.Done() == nil {
+		return f()
+	}
+	stop := make(chan bool)
+	aborted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.nc.SetDeadline(aLongTimeAgo)
+			aborted <- true
+		case <-stop:
+			aborted <- false
+		}
+	}()
+	err := f()
+	close(stop)
+	if <-aborted {
+		a.Close()
+		return ctx.Err()
+	}
+	return err
+}
+
+// NextContext returns the next APRS message from this connection,
+// giving up if the context is done first.  Giving up closes the
+// connection, since part of a line may have been read.
+func (a *APRSIS) NextContext(ctx context.Context) (rv aprs.Frame, err error) {
+	err = a.withContext(ctx, func() error {
+		var err error
+		rv, err = a.next()
+		return err
+	})
+	return rv, err
+}
+
+// AuthContext authenticates and optionally sets a filter, giving up
+// if the context is done first.  Giving up closes the connection,
+// since part of the login may have been sent.
+func (a *APRSIS) AuthContext(ctx context.Context, user, pass, filter string) error {
+	a.pass = pass
+	if filter != "" {
+		filter = fmt.Sprintf(" filter %s", filter)
+	}
+	return a.withContext(ctx, func() error {
+		return a.SendRawPacket("%s", fmt.Sprintf("user %s pass %s vers goaprs 0.1%s",
+			user, pass, filter))
+	})
+}
+
+// SendFrame sends an APRS frame.  The frame needn't have come from
+// ParseFrame, but it must have a source and destination and nothing
+// in it may break the line.
+func (a *APRSIS) SendFrame(f aprs.Frame) error {
+	if f.Source.Call == "" || f.Dest.Call == "" {
+		return errInvalidMsg
+	}
+	s := f.String()
+	if strings.ContainsAny(s, "\r\n") {
+		return errInvalidMsg
+	}
+	return a.SendRawPacket("%s", s)
+}
+
+// SetFilter replaces the server-side filter for this connection.
+func (a *APRSIS) SetFilter(filter string) error {
+	return a.SendRawPacket("#filter %s", filter)
+}
+
+// stream sends frames from next on a channel until it returns an
+// error other than ErrUnverified.
+func stream(ctx context.Context, next func(context.Context) (aprs.Frame, error)) <-chan aprs.Frame {
+	ch := make(chan aprs.Frame)
+	go func() {
+		defer close(ch)
+		for {
+			f, err := next(ctx)
+			switch err {
+			case nil:
+			case ErrUnverified:
+				continue
+			default:
+				return
+			}
+			select {
+			case ch <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Frames returns a channel of frames received on this connection.
+// The channel is closed when the context is done or the connection
+// fails.
+func (a *APRSIS) Frames(ctx context.Context) <-chan aprs.Frame {
+	return stream(ctx, func(ctx context.Context) (aprs.Frame, error) {
+		for {
+			f, err := a.NextContext(ctx)
+			if err != errInvalidMsg {
+				return f, err
+			}
+		}
+	})
+}
+
+// ErrNotConnected is returned when sending from a Client that isn't
+// connected.
+var ErrNotConnected = errors.New("not connected")
+
+// SendFrame sends an APRS frame on the current connection.
+func (c *Client) SendFrame(f aprs.Frame) error {
+	c.mu.Lock()
+	is := c.current
+	c.mu.Unlock()
+	if is == nil {
+		return ErrNotConnected
+	}
+	return is.SendFrame(f)
+}
+
+// SetFilter replaces the server-side filter, both on the current
+// connection and for future reconnects.
+func (c *Client) SetFilter(filter string) error {
+	c.mu.Lock()
+	c.filter = filter
+	is := c.current
+	c.mu.Unlock()
+	if is == nil {
+		return nil
+	}
+	return is.SetFilter(filter)
+}
+
+// Frames returns a channel of frames received by the client.  The
+// channel is closed when the context is done or the client is
+// closed.
+func (c *Client) Frames(ctx context.Context) <-chan aprs.Frame {
+	return stream(ctx, c.NextContext)
+}
@@ -0,0 +1,168 @@
+package aprsis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/aprsis/aprsistest"
+)
+
+// recordingServer accepts one connection, sends the given lines and
+// reports every line the client sends.
+func recordingServer(t *testing.T, send []string) (string, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	got := make(chan string, 10)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, l := range send {
+			fmt.Fprintf(conn, "%s\r\n", l)
+		}
+		r := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			line, err := r.ReadLine()
+			if err != nil {
+				close(got)
+				return
+			}
+			got <- line
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func expectSent(t *testing.T, got chan string, exp string) {
+	select {
+	case line := <-got:
+		if line != exp {
+			t.Fatalf("Expected %q to be sent, got %q", exp, line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %q", exp)
+	}
+}
+
+func TestContextAPI(t *testing.T) {
+	addr, got := recordingServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	is, err := DialContext(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer is.Close()
+
+	if err := is.AuthContext(ctx, "KG6HWF", "22955", "p/KG"); err != nil {
+		t.Fatalf("Error authenticating: %v", err)
+	}
+	expectSent(t, got, "user KG6HWF pass 22955 vers goaprs 0.1 filter p/KG")
+
+	if err := is.SetFilter("r/37/-122/50"); err != nil {
+		t.Fatalf("Error setting filter: %v", err)
+	}
+	expectSent(t, got, "#filter r/37/-122/50")
+
+	f := aprs.ParseFrame("KG6HWF>APRS,TCPIP*:>100% %s working")
+	if err := is.SendFrame(f); err != nil {
+		t.Fatalf("Error sending frame: %v", err)
+	}
+	expectSent(t, got, "KG6HWF>APRS,TCPIP*:>100% %s working")
+
+	built := aprs.Frame{
+		Source: aprs.Address{Call: "KG6HWF", SSID: "9"},
+		Dest:   aprs.Address{Call: "APRS"},
+		Path:   []aprs.Address{{Call: "TCPIP*"}},
+		Body:   aprs.Info(">built in code"),
+	}
+	if err := is.SendFrame(built); err != nil {
+		t.Fatalf("Error sending a constructed frame: %v", err)
+	}
+	expectSent(t, got, "KG6HWF-9>APRS,TCPIP*:>built in code")
+
+	for _, bad := range []aprs.Frame{
+		{Body: aprs.Info(">no source")},
+		{Source: aprs.Address{Call: "KG6HWF"}, Body: aprs.Info(">no dest")},
+		{Source: aprs.Address{Call: "KG6HWF"}, Dest: aprs.Address{Call: "APRS"},
+			Body: aprs.Info(">two\r\nlines")},
+		{Source: aprs.Address{Call: "KG6HWF"}, Dest: aprs.Address{Call: "APRS"},
+			Path: []aprs.Address{{Call: "WIDE1\n"}}, Body: aprs.Info(">x")},
+	} {
+		if err := is.SendFrame(bad); err == nil {
+			t.Errorf("Expected error sending invalid frame %q", bad.String())
+		}
+	}
+
+	// Nothing is coming, so a read gives up when cancelled.
+	rctx, rcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer rcancel()
+	if _, err := is.NextContext(rctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+
+	// Part of a line may have been read, so it's not reused.
+	if err := is.SendFrame(f); err == nil {
+		t.Fatalf("Expected the connection to be closed after a cancelled read")
+	}
+}
+
+func TestClientReconnectsAfterCancel(t *testing.T) {
+	s := aprsistest.NewServer()
+	defer s.Close()
+
+	c := NewClient([]string{s.Addr}, "KG6HWF", "-1", "")
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rctx, rcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer rcancel()
+	if _, err := c.NextContext(rctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	accept(t, s)
+
+	go func() {
+		if conn, err := s.Accept(); err == nil {
+			conn.Send("KG6HWF>APRS:>again")
+		}
+	}()
+	f, err := c.NextContext(ctx)
+	if err != nil || string(f.Body) != ">again" {
+		t.Fatalf("Expected a frame from a new connection, got %v/%v", f, err)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	addr, _ := recordingServer(t, []string{"# aprsc 2.1.10",
+		"KG6HWF>APRS:>one", "garbage", "KG6HWF>APRS:>two"})
+
+	c := NewClient([]string{addr}, "KG6HWF", "-1", "")
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	for f := range c.Frames(ctx) {
+		got = append(got, string(f.Body))
+		if len(got) == 2 {
+			cancel()
+		}
+	}
+	if len(got) != 2 || got[0] != ">one" || got[1] != ">two" {
+		t.Errorf("Expected two frames, got %q", got)
+	}
+}
@@ -0,0 +1,160 @@
+package aprsis
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrUnverified is returned when the server didn't accept the
+// passcode given at login.
+var ErrUnverified = errors.New("login unverified")
+
+// EventType identifies the kind of server comment in an Event.
+type EventType int
+
+const (
+	// CommentEvent is any comment that isn't recognized.
+	CommentEvent EventType = iota
+	// BannerEvent is the software banner sent on connect.
+	BannerEvent
+	// KeepaliveEvent is a periodic comment with the server time.
+	KeepaliveEvent
+	// LoginEvent is the server's response to our login.
+	LoginEvent
+)
+
+var eventTypeNames = map[EventType]string{
+	CommentEvent:   "comment",
+	BannerEvent:    "banner",
+	KeepaliveEvent: "keepalive",
+	LoginEvent:     "login",
+}
+
+func (t EventType) String() string {
+	if n, ok := eventTypeNames[t]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// LoginStatus is how the server accepted our login.
+type LoginStatus int
+
+const (
+	// LoginPending means the server hasn't responded to the login.
+	LoginPending LoginStatus = iota
+	// LoginVerified means the passcode was accepted.
+	LoginVerified
+	// LoginUnverified means the login is receive-only.
+	LoginUnverified
+)
+
+var loginStatusNames = map[LoginStatus]string{
+	LoginPending:    "pending",
+	LoginVerified:   "verified",
+	LoginUnverified: "unverified",
+}
+
+func (s LoginStatus) String() string {
+	if n, ok := loginStatusNames[s]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// An Event is a parsed server comment line.
+type Event struct {
+	Type EventType
+	// Raw is the line as received.
+	Raw string
+
+	// Software and Version are set for banners and keepalives.
+	Software, Version string
+	// Time is the server time from a keepalive.
+	Time time.Time
+	// Server is the server name from a keepalive or login response.
+	Server string
+
+	// Call and Status are set for login responses.
+	Call   string
+	Status LoginStatus
+}
+
+// EventHandler is a handler for parsed server comments.
+type EventHandler interface {
+	Event(e Event)
+}
+
+type dumbEventHandlerT struct{}
+
+func (d dumbEventHandlerT) Event(e Event) {
+}
+
+var dumbEventHandler dumbEventHandlerT
+
+// keepaliveTime is the layout of the time in server keepalives,
+// e.g. "19 Oct 2026 12:34:56 GMT".
+const keepaliveTime = "2 Jan 2006 15:04:05 MST"
+
+// ParseEvent parses a server comment line.  Banners can't be told
+// apart from other comments by their content, so they're only
+// recognized by APRSIS as the first line on a connection.
+func ParseEvent(line string) Event {
+	rv := Event{Raw: line}
+	fields := strings.Fields(strings.TrimPrefix(line, "#"))
+	if len(fields) == 0 {
+		return rv
+	}
+
+	if fields[0] == "logresp" && len(fields) >= 3 {
+		rv.Type = LoginEvent
+		rv.Call = fields[1]
+		switch strings.TrimSuffix(fields[2], ",") {
+		case "verified":
+			rv.Status = LoginVerified
+		case "unverified":
+			rv.Status = LoginUnverified
+		default:
+			rv.Type = CommentEvent
+			rv.Call = ""
+			return rv
+		}
+		if len(fields) >= 5 && fields[3] == "server" {
+			rv.Server = fields[4]
+		}
+		return rv
+	}
+
+	// Banners and keepalives look like
+	// "# aprsc 2.1.10-gd72a17c 19 Oct 2026 12:34:56 GMT T2SPAIN 1.2.3.4:14580"
+	// with everything from the time on present only in keepalives.
+	for i := 1; i+5 <= len(fields); i++ {
+		t, err := time.Parse(keepaliveTime, strings.Join(fields[i:i+5], " "))
+		if err != nil {
+			continue
+		}
+		rv.Type = KeepaliveEvent
+		rv.Software = fields[0]
+		rv.Version = strings.Join(fields[1:i], " ")
+		rv.Time = t
+		if i+5 < len(fields) {
+			rv.Server = fields[i+5]
+		}
+		return rv
+	}
+	return rv
+}
+
+// asBanner reinterprets a comment as the software banner a server
+// sends first on a new connection, e.g. "# aprsc 2.1.10-gd72a17c".
+func (e Event) asBanner() Event {
+	fields := strings.Fields(strings.TrimPrefix(e.Raw, "#"))
+	if e.Type != CommentEvent || len(fields) == 0 {
+		return e
+	}
+	e.Type = BannerEvent
+	e.Software = fields[0]
+	e.Version = strings.Join(fields[1:], " ")
+	return e
+}
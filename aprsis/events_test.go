@@ -0,0 +1,87 @@
+package aprsis
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp Event
+	}{
+		{"# logresp KG6HWF verified, server T2SPAIN",
+			Event{Type: LoginEvent, Call: "KG6HWF", Status: LoginVerified, Server: "T2SPAIN"}},
+		{"# logresp KG6HWF-9 unverified, server GOAPRS",
+			Event{Type: LoginEvent, Call: "KG6HWF-9", Status: LoginUnverified, Server: "GOAPRS"}},
+		{"# logresp KG6HWF something",
+			Event{Type: CommentEvent}},
+		{"# aprsc 2.1.10-gd72a17c 19 Oct 2026 12:34:56 GMT T2SPAIN 1.2.3.4:14580",
+			Event{Type: KeepaliveEvent, Software: "aprsc", Version: "2.1.10-gd72a17c",
+				Time: time.Date(2026, 10, 19, 12, 34, 56, 0, time.UTC), Server: "T2SPAIN"}},
+		{"# javAPRSSrvr 4.3.2b09 9 Oct 2026 01:02:03 GMT WE7U-F2 1.2.3.4:14580",
+			Event{Type: KeepaliveEvent, Software: "javAPRSSrvr", Version: "4.3.2b09",
+				Time: time.Date(2026, 10, 9, 1, 2, 3, 0, time.UTC), Server: "WE7U-F2"}},
+		{"# goaprs 19 Oct 2026 12:34:56 GMT GOAPRS",
+			Event{Type: KeepaliveEvent, Software: "goaprs",
+				Time: time.Date(2026, 10, 19, 12, 34, 56, 0, time.UTC), Server: "GOAPRS"}},
+		{"# filter p/KG active", Event{Type: CommentEvent}},
+		{"#", Event{Type: CommentEvent}},
+	}
+
+	for _, test := range tests {
+		got := ParseEvent(test.in)
+		test.exp.Raw = test.in
+		// Compare times by instant, since the zone may differ.
+		if got.Time.Equal(test.exp.Time) {
+			got.Time = test.exp.Time
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("ParseEvent(%q) = %+v, want %+v", test.in, got, test.exp)
+		}
+	}
+}
+
+type eventRecorder chan Event
+
+func (r eventRecorder) Event(e Event) {
+	r <- e
+}
+
+func TestLoginStatus(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer ln.Close()
+
+	keep := make(chan bool)
+	defer close(keep)
+	serveOnce(t, ln, []string{"# aprsc 2.1.10-gd72a17c",
+		"# logresp KG6HWF unverified, server T2TEST",
+		"KG6HWF>APRS:>hi"}, keep)
+
+	c := NewClient([]string{ln.Addr().String()}, "KG6HWF", "12345", "")
+	defer c.Close()
+	events := make(eventRecorder, 10)
+	c.SetEventHandler(events)
+
+	if _, err := c.Next(); err != ErrUnverified {
+		t.Fatalf("Expected ErrUnverified, got %v", err)
+	}
+	if s := c.LoginStatus(); s != LoginUnverified {
+		t.Errorf("Expected unverified login status, got %v", s)
+	}
+	if f, err := c.Next(); err != nil || string(f.Body) != ">hi" {
+		t.Errorf("Expected to keep receiving, got %v/%v", f, err)
+	}
+
+	if e := <-events; e.Type != BannerEvent || e.Software != "aprsc" || e.Version != "2.1.10-gd72a17c" {
+		t.Errorf("Expected banner, got %+v", e)
+	}
+	if e := <-events; e.Type != LoginEvent || e.Server != "T2TEST" {
+		t.Errorf("Expected login response, got %+v", e)
+	}
+}
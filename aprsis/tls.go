@@ -0,0 +1,65 @@
+package aprsis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// DialTLS dials an APRS-IS service over TLS.  A nil config verifies
+// the server against the system roots.
+func DialTLS(prot, addr string, config *tls.Config) (*APRSIS, error) {
+	return DialTLSContext(context.Background(), prot, addr, config)
+}
+
+// DialTLSContext dials an APRS-IS service over TLS, giving up if the
+// context is done before the connection is established.
+func DialTLSContext(ctx context.Context, prot, addr string, config *tls.Config) (*APRSIS, error) {
+	d := tls.Dialer{Config: config}
+	nc, err := d.DialContext(ctx, prot, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newAPRSIS(nc), nil
+}
+
+// TLSConfig builds a TLS client configuration.  caFile, if not
+// empty, is a PEM file of roots to verify the server with instead of
+// the system roots.  certFile and keyFile, if not empty, are a PEM
+// client certificate and key to present to the server.  insecure
+// disables server verification entirely.
+func TLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// SetTLSConfig makes the client connect over TLS with the given
+// configuration.  A nil config goes back to plain TCP.
+func (c *Client) SetTLSConfig(config *tls.Config) {
+	if config == nil {
+		c.dial = DialContext
+		return
+	}
+	c.dial = func(ctx context.Context, prot, addr string) (*APRSIS, error) {
+		return DialTLSContext(ctx, prot, addr, config)
+	}
+}
@@ -0,0 +1,158 @@
+package aprsis
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert creates a self-signed certificate for 127.0.0.1 usable
+// by both servers and clients, and writes it and its key as PEM
+// files in dir.
+func testCert(t *testing.T, dir, name string) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Error writing cert: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Error loading key pair: %v", err)
+	}
+	return cert, certFile, keyFile
+}
+
+// tlsServer accepts one TLS connection and reports the login it
+// receives along with the client certificate's name, if any.
+func tlsServer(t *testing.T, config *tls.Config) (string, chan string) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	got := make(chan string, 2)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := textproto.NewReader(bufio.NewReader(conn))
+		line, err := r.ReadLine()
+		if err != nil {
+			close(got)
+			return
+		}
+		got <- line
+		if certs := conn.(*tls.Conn).ConnectionState().PeerCertificates; len(certs) > 0 {
+			got <- certs[0].Subject.CommonName
+		}
+		conn.Write([]byte("KG6HWF>APRS:>secure\r\n"))
+		r.ReadLine()
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, caFile, _ := testCert(t, dir, "server")
+	_, certFile, keyFile := testCert(t, dir, "station")
+
+	clientCAs := x509.NewCertPool()
+	pemData, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("Error reading cert: %v", err)
+	}
+	clientCAs.AppendCertsFromPEM(pemData)
+
+	addr, got := tlsServer(t, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+
+	config, err := TLSConfig(caFile, certFile, keyFile, false)
+	if err != nil {
+		t.Fatalf("Error creating TLS config: %v", err)
+	}
+	c := NewClient([]string{addr}, "KG6HWF", "22955", "")
+	c.SetTLSConfig(config)
+	defer c.Close()
+
+	f, err := c.Next()
+	if err != nil || string(f.Body) != ">secure" {
+		t.Fatalf("Expected frame over TLS, got %v/%v", f, err)
+	}
+	expectSent(t, got, "user KG6HWF pass 22955 vers goaprs 0.1")
+	expectSent(t, got, "station")
+}
+
+func TestTLSUnverified(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, _, _ := testCert(t, dir, "server")
+	addr, _ := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+
+	// The server's certificate isn't in the system roots.
+	if is, err := DialTLS("tcp", addr, nil); err == nil {
+		is.Close()
+		t.Fatalf("Expected verification failure")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	for _, args := range [][3]string{
+		{filepath.Join(dir, "missing.pem"), "", ""},
+		{empty, "", ""},
+		{"", empty, empty},
+	} {
+		if _, err := TLSConfig(args[0], args[1], args[2], false); err == nil {
+			t.Errorf("Expected error for TLSConfig%q", args)
+		}
+	}
+}
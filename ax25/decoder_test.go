@@ -113,3 +113,29 @@ func TestCapture(t *testing.T) {
 		t.Fatalf("Expected:\n%#v\nGot:\n%#v", expected, got)
 	}
 }
+
+func TestCaptureMarkRepeated(t *testing.T) {
+	f, err := os.Open("radio.sample")
+	if err != nil {
+		t.Fatalf("Error opening sample file: %v", err)
+	}
+	defer f.Close()
+
+	expected := []string{
+		"N6WKZ-3>APU25N-0,WR6ABD-0*:=3746.42N112226.00W# {UIV32N}\r",
+		"W1EJ-10>APT311-0,WB6TMS-5,N6ZX-3,WIDE2-0*:/210725z3814.29N/12236.93W>275/000/A=000013/ED J SAG",
+		"WR6ABD-0>APN382-0:!3706.66NS12150.69W#PHG5730 W1,NCAn Loma Prieta LPRC.net A=003980\r",
+	}
+
+	d := NewDecoder(f)
+	d.SetMarkRepeated(true)
+	for _, exp := range expected {
+		m, err := d.Next()
+		if err != nil {
+			t.Fatalf("Error reading stream: %v", err)
+		}
+		if m.String() != exp {
+			t.Errorf("Expected %q, got %q", exp, m.String())
+		}
+	}
+}
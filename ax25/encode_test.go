@@ -33,19 +33,40 @@ func TestAddressConversion(t *testing.T) {
 		{"KG6HWF-9",
 			[]byte{0x96, 0x8e, 0x6c, 0x90, 0xae, 0x8c, 0xf2},
 			[]byte{0x96, 0x8e, 0x6c, 0x90, 0xae, 0x8c, 0x72}},
+		// Short callsigns are padded with shifted spaces.
+		{"N6ACK",
+			[]byte{0x9c, 0x6c, 0x82, 0x86, 0x96, 0x40, 0xe0},
+			[]byte{0x9c, 0x6c, 0x82, 0x86, 0x96, 0x40, 0x60}},
+		{"K6B-7",
+			[]byte{0x96, 0x6c, 0x84, 0x40, 0x40, 0x40, 0xee},
+			[]byte{0x96, 0x6c, 0x84, 0x40, 0x40, 0x40, 0x6e}},
 	}
 
 	for _, ta := range testaddrs {
-		a := aprs.AddressFromString(ta.Src)
-		a25c := addressEncode(a, setSSIDMask)
+		a := fromAPRSAddress(aprs.AddressFromString(ta.Src))
+		a.C = true
+		a25c := a.encode(false)
 		if !reflect.DeepEqual(a25c, ta.AX25Cmd) {
 			t.Fatalf("Expected %v for AX25d %v, got %v",
 				ta.AX25Cmd, ta.Src, a25c)
 		}
-		a25r := addressEncode(a, clearSSIDMask)
+		a.C = false
+		a25r := a.encode(false)
 		if !reflect.DeepEqual(a25r, ta.AX25Res) {
 			t.Fatalf("Expected %v for AX25d %v, got %v",
 				ta.AX25Res, ta.Src, a25r)
 		}
 	}
 }
+
+func TestEncodeRepeated(t *testing.T) {
+	v := aprs.ParseFrame("KG6HWF>APX200,WR6ABD,KG6HWF-2*,WIDE2-1:>hi")
+	got, err := decode(EncodeAPRSCommand(v), true)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	exp := "KG6HWF-0>APX200-0,WR6ABD-0,KG6HWF-2*,WIDE2-1:>hi"
+	if got.String() != exp {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,461 @@
+package ax25
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-aprs"
+)
+
+// ErrNotAPRS is returned when converting a frame that isn't a UI
+// frame with no layer 3 protocol to APRS.
+var ErrNotAPRS = errors.New("not an APRS frame")
+
+// maxDigis is the most digipeaters an AX.25 frame can have.
+const maxDigis = 8
+
+// An Address is an AX.25 address field.
+type Address struct {
+	Call string
+	SSID int
+	// C is the command/response bit of a destination or source
+	// address.  For a digipeater address it's the has-been-repeated
+	// (H) bit.
+	C bool
+}
+
+// H is true if this digipeater address has been repeated.
+func (a Address) H() bool {
+	return a.C
+}
+
+func (a Address) String() string {
+	s := a.Call
+	if a.SSID != 0 {
+		s += "-" + strconv.Itoa(a.SSID)
+	}
+	return s
+}
+
+func decodeAddress(in []byte) Address {
+	out := make([]byte, 6)
+	for i := range out {
+		out[i] = in[i] >> 1
+	}
+	return Address{
+		Call: strings.TrimSpace(string(out)),
+		SSID: int(in[6]>>1) & 0xf,
+		C:    in[6]&repeatedMask != 0,
+	}
+}
+
+func (a Address) encode(last bool) []byte {
+	rv := make([]byte, 7)
+	for i := 0; i < 6; i++ {
+		rv[i] = ' ' << 1
+	}
+	for i := 0; i < len(a.Call) && i < 6; i++ {
+		rv[i] = a.Call[i] << 1
+	}
+	rv[6] = clearSSIDMask | byte(a.SSID&0xf)<<1
+	if a.C {
+		rv[6] |= repeatedMask
+	}
+	if last {
+		rv[6] |= 1
+	}
+	return rv
+}
+
+// A FrameKind is the broad kind of an AX.25 frame.
+type FrameKind int
+
+// The kinds of AX.25 frame.
+const (
+	// IFrame is an information frame in a connection.
+	IFrame FrameKind = iota
+	// SFrame is a supervisory frame in a connection.
+	SFrame
+	// UFrame is an unnumbered frame.
+	UFrame
+)
+
+func (k FrameKind) String() string {
+	switch k {
+	case IFrame:
+		return "I"
+	case SFrame:
+		return "S"
+	}
+	return "U"
+}
+
+// Supervisory frame types, the control field with N(R) and P/F
+// clear.
+const (
+	RR   = 0x01
+	RNR  = 0x05
+	REJ  = 0x09
+	SREJ = 0x0d
+)
+
+// Unnumbered frame types, the control field with P/F clear.
+const (
+	SABME = 0x6f
+	SABM  = 0x2f
+	DISC  = 0x43
+	DM    = 0x0f
+	UA    = 0x63
+	FRMR  = 0x87
+	UI    = 0x03
+	XID   = 0xaf
+	TEST  = 0xe3
+)
+
+// Protocol identifiers.
+const (
+	PIDX25    = byte(0x01)
+	PIDIP     = byte(0xcc)
+	PIDARP    = byte(0xcd)
+	PIDNetROM = byte(0xcf)
+	PIDNoL3   = byte(0xf0)
+)
+
+// pfMask is the poll/final bit of the control field, and pfMask128
+// the same in a modulo 128 control field.
+const (
+	pfMask    = 0x10
+	pfMask128 = 0x100
+)
+
+var frameTypeNames = map[byte]string{
+	RR: "RR", RNR: "RNR", REJ: "REJ", SREJ: "SREJ",
+	SABME: "SABME", SABM: "SABM", DISC: "DISC", DM: "DM", UA: "UA",
+	FRMR: "FRMR", UI: "UI", XID: "XID", TEST: "TEST",
+}
+
+// IControl returns the control field for a modulo 8 I frame.
+func IControl(nr, ns int, poll bool) uint16 {
+	c := uint16(nr&7)<<5 | uint16(ns&7)<<1
+	if poll {
+		c |= pfMask
+	}
+	return c
+}
+
+// SControl returns the control field for a modulo 8 S frame of the
+// given type.
+func SControl(t byte, nr int, pf bool) uint16 {
+	c := uint16(t) | uint16(nr&7)<<5
+	if pf {
+		c |= pfMask
+	}
+	return c
+}
+
+// IControl128 returns the control field for a modulo 128 I frame.
+func IControl128(nr, ns int, poll bool) uint16 {
+	c := uint16(nr&0x7f)<<9 | uint16(ns&0x7f)<<1
+	if poll {
+		c |= pfMask128
+	}
+	return c
+}
+
+// SControl128 returns the control field for a modulo 128 S frame of
+// the given type.
+func SControl128(t byte, nr int, pf bool) uint16 {
+	c := uint16(t) | uint16(nr&0x7f)<<9
+	if pf {
+		c |= pfMask128
+	}
+	return c
+}
+
+// UControl returns the control field for a U frame of the given
+// type.
+func UControl(t byte, pf bool) uint16 {
+	c := uint16(t)
+	if pf {
+		c |= pfMask
+	}
+	return c
+}
+
+// A Frame is an AX.25 frame.
+type Frame struct {
+	Dest, Source Address
+	// Path holds the digipeater addresses.
+	Path []Address
+	// Control is the control field.  I and S frames on modulo 128
+	// links have two octets, the first in the low byte; everything
+	// else has one.
+	Control uint16
+	// Modulo128 is true for frames on a modulo 128 (AX.25 v2.2
+	// extended) link.  Nothing in the frame says which it is, so
+	// it has to come from the link's state.
+	Modulo128 bool
+	// PID is the protocol identifier of I and UI frames.
+	PID  byte
+	Info []byte
+}
+
+// Kind returns the broad kind of frame.
+func (f Frame) Kind() FrameKind {
+	switch {
+	case f.Control&1 == 0:
+		return IFrame
+	case f.Control&3 == 1:
+		return SFrame
+	}
+	return UFrame
+}
+
+// Type returns the S or U frame type (e.g. RR or SABM), or 0 for I
+// frames.
+func (f Frame) Type() byte {
+	switch f.Kind() {
+	case SFrame:
+		return byte(f.Control & 0x0f)
+	case UFrame:
+		return byte(f.Control) &^ pfMask
+	}
+	return 0
+}
+
+// extended is true if the frame has a two octet control field.
+func (f Frame) extended() bool {
+	return f.Modulo128 && f.Kind() != UFrame
+}
+
+// NR returns the receive sequence number of I and S frames.
+func (f Frame) NR() int {
+	if f.extended() {
+		return int(f.Control>>9) & 0x7f
+	}
+	return int(f.Control>>5) & 7
+}
+
+// NS returns the send sequence number of I frames.
+func (f Frame) NS() int {
+	if f.extended() {
+		return int(f.Control>>1) & 0x7f
+	}
+	return int(f.Control>>1) & 7
+}
+
+// PF returns the poll/final bit.
+func (f Frame) PF() bool {
+	if f.extended() {
+		return f.Control&pfMask128 != 0
+	}
+	return f.Control&pfMask != 0
+}
+
+// HasPID is true for frames with a PID field (I and UI frames).
+func (f Frame) HasPID() bool {
+	return f.Kind() == IFrame || f.Type() == UI
+}
+
+// IsCommand is true if the C bits mark this as a command frame.
+func (f Frame) IsCommand() bool {
+	return f.Dest.C && !f.Source.C
+}
+
+// IsResponse is true if the C bits mark this as a response frame.
+func (f Frame) IsResponse() bool {
+	return !f.Dest.C && f.Source.C
+}
+
+// IsAPRS is true if this frame could carry APRS: a UI frame with no
+// layer 3 protocol.
+func (f Frame) IsAPRS() bool {
+	return f.Kind() == UFrame && f.Type() == UI && f.PID == PIDNoL3
+}
+
+// DecodeFrame decodes an AX.25 frame, without its FCS, as modulo 8.
+func DecodeFrame(b []byte) (rv Frame, err error) {
+	return decodeFrame(b, false)
+}
+
+// DecodeFrame128 decodes an AX.25 frame, without its FCS, from a
+// modulo 128 link.
+func DecodeFrame128(b []byte) (rv Frame, err error) {
+	return decodeFrame(b, true)
+}
+
+func decodeFrame(b []byte, modulo128 bool) (rv Frame, err error) {
+	rv.Modulo128 = modulo128
+	if len(b) < reasonableSize {
+		return rv, errShortMsg
+	}
+	rv.Dest = decodeAddress(b[0:7])
+	rv.Source = decodeAddress(b[7:14])
+	last := b[13]&1 != 0
+	b = b[14:]
+	for !last {
+		if len(b) < 7 || len(rv.Path) == maxDigis {
+			return rv, errTruncatedMsg
+		}
+		rv.Path = append(rv.Path, decodeAddress(b[:7]))
+		last = b[6]&1 != 0
+		b = b[7:]
+	}
+
+	if len(b) < 1 {
+		return rv, errTruncatedMsg
+	}
+	rv.Control = uint16(b[0])
+	b = b[1:]
+	if rv.extended() {
+		if len(b) < 1 {
+			return rv, errTruncatedMsg
+		}
+		rv.Control |= uint16(b[0]) << 8
+		b = b[1:]
+	}
+	if rv.HasPID() {
+		if len(b) < 1 {
+			return rv, errTruncatedMsg
+		}
+		rv.PID = b[0]
+		b = b[1:]
+	}
+	rv.Info = append([]byte{}, b...)
+	return rv, nil
+}
+
+// Encode encodes the frame, without an FCS.
+func (f Frame) Encode() []byte {
+	b := &bytes.Buffer{}
+	b.Write(f.Dest.encode(false))
+	b.Write(f.Source.encode(len(f.Path) == 0))
+	for i, p := range f.Path {
+		b.Write(p.encode(i == len(f.Path)-1))
+	}
+	b.WriteByte(byte(f.Control))
+	if f.extended() {
+		b.WriteByte(byte(f.Control >> 8))
+	}
+	if f.HasPID() {
+		b.WriteByte(f.PID)
+	}
+	b.Write(f.Info)
+	return b.Bytes()
+}
+
+// String describes the frame in monitor format, e.g.
+// "KG6HWF>APRS,WIDE2-1* <UI C pid=F0>:hello".
+func (f Frame) String() string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "%v>%v", f.Source, f.Dest)
+	for _, p := range f.Path {
+		b.WriteString("," + p.String())
+		if p.H() {
+			b.WriteByte('*')
+		}
+	}
+
+	b.WriteString(" <")
+	if f.Kind() == IFrame {
+		b.WriteString("I")
+	} else if n, ok := frameTypeNames[f.Type()]; ok {
+		b.WriteString(n)
+	} else {
+		fmt.Fprintf(b, "%v %02X", f.Kind(), f.Control)
+	}
+	switch {
+	case f.IsCommand():
+		b.WriteString(" C")
+	case f.IsResponse():
+		b.WriteString(" R")
+	}
+	if f.PF() {
+		if f.IsResponse() {
+			b.WriteString(" F")
+		} else {
+			b.WriteString(" P")
+		}
+	}
+	switch f.Kind() {
+	case IFrame:
+		fmt.Fprintf(b, " S%d R%d", f.NS(), f.NR())
+	case SFrame:
+		fmt.Fprintf(b, " R%d", f.NR())
+	}
+	if f.HasPID() {
+		fmt.Fprintf(b, " pid=%02X", f.PID)
+	}
+	b.WriteString(">")
+	if len(f.Info) > 0 {
+		b.WriteString(":" + string(f.Info))
+	}
+	return b.String()
+}
+
+func toAPRSAddress(a Address) aprs.Address {
+	return aprs.Address{Call: a.Call, SSID: strconv.Itoa(a.SSID)}
+}
+
+// APRS converts a UI frame to an APRS frame.  If markRepeated is
+// true, the last repeated digipeater is marked with a *, as in TNC2
+// format.
+func (f Frame) APRS(markRepeated bool) (rv aprs.Frame, err error) {
+	if !f.IsAPRS() {
+		return rv, ErrNotAPRS
+	}
+	rv.Source = toAPRSAddress(f.Source)
+	rv.Dest = toAPRSAddress(f.Dest)
+	rv.Path = []aprs.Address{}
+	lastRepeated := -1
+	for i, p := range f.Path {
+		if p.H() {
+			lastRepeated = i
+		}
+		rv.Path = append(rv.Path, toAPRSAddress(p))
+	}
+	if markRepeated && lastRepeated >= 0 {
+		rv.Path[lastRepeated] = rv.Path[lastRepeated].Repeated()
+	}
+	rv.Body = aprs.Info(string(f.Info))
+	return rv, nil
+}
+
+func fromAPRSAddress(a aprs.Address) Address {
+	ssid, err := strconv.Atoi(a.SSID)
+	if err != nil {
+		ssid = 0
+	}
+	return Address{Call: a.Call, SSID: ssid}
+}
+
+// FrameFromAPRS builds a UI frame carrying an APRS frame, as a
+// command or a response.  Path addresses marked with a *, and those
+// before them, have their H bits set.
+func FrameFromAPRS(m aprs.Frame, command bool) Frame {
+	f := Frame{
+		Dest:    fromAPRSAddress(m.Dest),
+		Source:  fromAPRSAddress(m.Source),
+		Control: UI,
+		PID:     PIDNoL3,
+		Info:    []byte(m.Body),
+	}
+	f.Dest.C = command
+	f.Source.C = !command
+
+	lastRepeated := -1
+	for i, p := range m.Path {
+		if p.IsRepeated() {
+			lastRepeated = i
+		}
+	}
+	for i, p := range m.Path {
+		a := fromAPRSAddress(p.Unrepeated())
+		a.C = i <= lastRepeated
+		f.Path = append(f.Path, a)
+	}
+	return f
+}
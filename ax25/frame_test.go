@@ -0,0 +1,168 @@
+package ax25
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/dustin/go-aprs"
+)
+
+func TestFrameControl(t *testing.T) {
+	tests := []struct {
+		control uint16
+		m128    bool
+		kind    FrameKind
+		typ     byte
+		nr, ns  int
+		pf      bool
+		pid     bool
+	}{
+		{IControl(5, 2, true), false, IFrame, 0, 5, 2, true, true},
+		{IControl(0, 7, false), false, IFrame, 0, 0, 7, false, true},
+		{SControl(RR, 3, false), false, SFrame, RR, 3, 0, false, false},
+		{SControl(REJ, 7, true), false, SFrame, REJ, 7, 0, true, false},
+		{UControl(SABM, true), false, UFrame, SABM, 0, 0, true, false},
+		{UControl(UA, false), false, UFrame, UA, 0, 0, false, false},
+		{UControl(UI, false), false, UFrame, UI, 0, 0, false, true},
+		{IControl128(100, 127, true), true, IFrame, 0, 100, 127, true, true},
+		{IControl128(9, 0, false), true, IFrame, 0, 9, 0, false, true},
+		{SControl128(SREJ, 77, true), true, SFrame, SREJ, 77, 0, true, false},
+		{SControl128(RNR, 0, false), true, SFrame, RNR, 0, 0, false, false},
+		{UControl(SABME, true), true, UFrame, SABME, 0, 0, true, false},
+	}
+	for _, test := range tests {
+		f := Frame{Control: test.control, Modulo128: test.m128}
+		if f.Kind() != test.kind || f.Type() != test.typ || f.PF() != test.pf ||
+			f.HasPID() != test.pid {
+			t.Errorf("Control %02x: got %v %02x pf=%v pid=%v", test.control,
+				f.Kind(), f.Type(), f.PF(), f.HasPID())
+		}
+		if test.kind != UFrame && f.NR() != test.nr {
+			t.Errorf("Control %02x: N(R) = %v, want %v", test.control, f.NR(), test.nr)
+		}
+		if test.kind == IFrame && f.NS() != test.ns {
+			t.Errorf("Control %02x: N(S) = %v, want %v", test.control, f.NS(), test.ns)
+		}
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Dest: Address{Call: "KG6HWF", SSID: 1, C: true}, Source: Address{Call: "N6ACK"},
+			Control: IControl(1, 2, false), PID: PIDNetROM, Info: []byte("netrom")},
+		{Dest: Address{Call: "KG6HWF"}, Source: Address{Call: "N6ACK", SSID: 15, C: true},
+			Path:    []Address{{Call: "WR6ABD", C: true}, {Call: "WIDE2", SSID: 1}},
+			Control: SControl(RNR, 4, true)},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UControl(SABM, true)},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: IControl128(100, 64, true), Modulo128: true, PID: PIDNoL3, Info: []byte("v2.2")},
+		{Dest: Address{Call: "KG6HWF"}, Source: Address{Call: "N6ACK", C: true},
+			Control: SControl128(REJ, 127, false), Modulo128: true},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UControl(DISC, true), Modulo128: true},
+	}
+	for _, f := range frames {
+		decode := DecodeFrame
+		if f.Modulo128 {
+			decode = DecodeFrame128
+		}
+		got, err := decode(f.Encode())
+		if err != nil {
+			t.Errorf("Error decoding %v: %v", f, err)
+			continue
+		}
+		if len(got.Info) == 0 && len(f.Info) == 0 {
+			got.Info = f.Info
+		}
+		if !reflect.DeepEqual(got, f) {
+			t.Errorf("Expected %v, got %v", f, got)
+		}
+	}
+}
+
+func TestFrameString(t *testing.T) {
+	tests := []struct {
+		f   Frame
+		exp string
+	}{
+		{FrameFromAPRS(aprs.ParseFrame("KG6HWF>APRS,WR6ABD*,WIDE2-1:>hi"), true),
+			"KG6HWF>APRS,WR6ABD*,WIDE2-1 <UI C pid=F0>:>hi"},
+		{Frame{Dest: Address{Call: "KG6HWF"}, Source: Address{Call: "N6ACK", C: true},
+			Control: SControl(RR, 3, true)},
+			"N6ACK>KG6HWF <RR R F R3>"},
+		{Frame{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: IControl(1, 2, true), PID: PIDNoL3, Info: []byte("hello")},
+			"N6ACK>KG6HWF <I C P S2 R1 pid=F0>:hello"},
+		{Frame{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: IControl128(99, 42, false), Modulo128: true, PID: PIDNoL3, Info: []byte("hi")},
+			"N6ACK>KG6HWF <I C S42 R99 pid=F0>:hi"},
+		{Frame{Dest: Address{Call: "KG6HWF"}, Source: Address{Call: "N6ACK"},
+			Control: 0x8b},
+			"N6ACK>KG6HWF <U 8B>"},
+	}
+	for _, test := range tests {
+		if got := test.f.String(); got != test.exp {
+			t.Errorf("Expected %q, got %q", test.exp, got)
+		}
+	}
+}
+
+func TestFrameCapture(t *testing.T) {
+	f, err := os.Open("radio.sample")
+	if err != nil {
+		t.Fatalf("Error opening sample file: %v", err)
+	}
+	defer f.Close()
+
+	d := NewKISSDecoder(f)
+	n := 0
+	for {
+		k, err := d.Next()
+		if err != nil {
+			break
+		}
+		fr, err := DecodeFrame(k.Data)
+		if err != nil {
+			t.Fatalf("Error decoding frame %v: %v", n, err)
+		}
+		if !fr.IsAPRS() {
+			t.Errorf("Expected APRS frame, got %v", fr)
+		}
+		if got := fr.Encode(); !bytes.Equal(got, k.Data) {
+			t.Errorf("Frame %v re-encoded as % x, want % x", n, got, k.Data)
+		}
+		n++
+	}
+	if n == 0 {
+		t.Fatalf("No frames decoded")
+	}
+}
+
+func TestDecoderSkipsNonAPRS(t *testing.T) {
+	b := &bytes.Buffer{}
+	e := NewKISSEncoder(b)
+	e.Send(0, Frame{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+		Control: UControl(SABM, true)}.Encode())
+	e.Send(0, Frame{Dest: Address{Call: "NODES", C: true}, Source: Address{Call: "N6ACK"},
+		Control: UI, PID: PIDNetROM, Info: []byte{0xff}}.Encode())
+	e.SendAPRS(0, aprs.ParseFrame("KG6HWF>APRS:>hi"))
+
+	got, err := NewDecoder(b).Next()
+	if err != nil || string(got.Body) != ">hi" {
+		t.Fatalf("Expected the APRS frame, got %v/%v", got, err)
+	}
+}
+
+func TestNotAPRS(t *testing.T) {
+	f := Frame{Control: UControl(SABM, true)}
+	if _, err := f.APRS(false); err != ErrNotAPRS {
+		t.Errorf("Expected ErrNotAPRS, got %v", err)
+	}
+	if _, err := DecodeFrame(make([]byte, 20)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected a malformed frame error, got %v", err)
+	}
+}
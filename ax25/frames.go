@@ -2,130 +2,103 @@
 package ax25
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
+	"fmt"
 	"io"
-	"strconv"
-	"strings"
 
 	"github.com/dustin/go-aprs"
 )
 
 const reasonableSize = 14
 
-var errShortMsg = errors.New("short message")
-var errTruncatedMsg = errors.New("truncated message")
+// ErrMalformed is the error underlying all failures to decode a
+// frame.
+var ErrMalformed = errors.New("malformed frame")
 
-var setSSIDMask = byte(0x70 << 1)
-var clearSSIDMask = byte(0x30 << 1)
+var errShortMsg = fmt.Errorf("%w: short message", ErrMalformed)
+var errTruncatedMsg = fmt.Errorf("%w: truncated message", ErrMalformed)
 
-func parseAddr(in []byte) aprs.Address {
-	out := make([]byte, len(in))
-	for i, b := range in {
-		out[i] = b >> 1
-	}
-	rv := aprs.Address{
-		Call: strings.TrimSpace(string(out[:len(out)-1])),
-		SSID: strconv.Itoa(int((out[len(out)-1]) & 0xf)),
-	}
-	return rv
-}
+var clearSSIDMask = byte(0x30 << 1)
+var repeatedMask = byte(0x80)
 
+// decodeMessage decodes a KISS data frame that still has its type
+// byte and trailing FEND.
 func decodeMessage(frame []byte) (rv aprs.Frame, err error) {
 	if len(frame) < reasonableSize+1 {
 		err = errShortMsg
 		return
 	}
+	return decode(frame[1:len(frame)-1], false)
+}
 
-	frame = frame[:len(frame)-1]
-
-	rv.Source = parseAddr(frame[8:15])
-	rv.Dest = parseAddr(frame[1:8])
-
-	rv.Path = []aprs.Address{}
-
-	frame = frame[15:]
-	for len(frame) > 7 && frame[0] != 3 {
-		rv.Path = append(rv.Path, parseAddr(frame[:7]))
-		frame = frame[7:]
-	}
-
-	if len(frame) < 2 || frame[0] != 3 || frame[1] != 0xf0 {
-		err = errTruncatedMsg
-		return
+// decode decodes an AX.25 UI frame.
+func decode(frame []byte, markRepeated bool) (aprs.Frame, error) {
+	f, err := DecodeFrame(frame)
+	if err != nil {
+		return aprs.Frame{}, err
 	}
-
-	rv.Body = aprs.Info(string(frame[2:]))
-
-	return
+	return f.APRS(markRepeated)
 }
 
-// Decoder is an AX.25 message decoder.
+// Decoder is an AX.25 message decoder reading from a KISS TNC.
 type Decoder struct {
-	r *bufio.Reader
+	k            *KISSDecoder
+	port         int
+	markRepeated bool
 }
 
-// Next gets the next message.
+// NextFrame gets the next AX.25 frame of any kind.  KISS commands
+// other than data frames are skipped.
+func (d *Decoder) NextFrame() (Frame, error) {
+	for {
+		f, err := d.k.Next()
+		if err != nil {
+			return Frame{}, err
+		}
+		if f.Command != KISSData || len(f.Data) < reasonableSize {
+			continue
+		}
+		d.port = f.Port
+		return DecodeFrame(f.Data)
+	}
+}
+
+// Next gets the next APRS message, skipping frames that can't carry
+// APRS.
 func (d *Decoder) Next() (aprs.Frame, error) {
-	frame := []byte{}
-	var err error
-	for len(frame) < reasonableSize {
-		frame, err = d.r.ReadBytes(byte(0xc0))
+	for {
+		f, err := d.NextFrame()
 		if err != nil {
 			return aprs.Frame{}, err
 		}
+		if f.IsAPRS() {
+			return f.APRS(d.markRepeated)
+		}
 	}
-	return decodeMessage(frame)
 }
 
-// NewDecoder gets a new decoder over this reader.
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{bufio.NewReader(r)}
+// Port returns the TNC port the last message was received on.
+func (d *Decoder) Port() int {
+	return d.port
 }
 
-func addressEncode(a aprs.Address, ssidMask byte) []byte {
-	rv := make([]byte, 7)
-	for i := 0; i < len(rv); i++ {
-		rv[i] = ' '
-	}
-	for i, c := range a.Call {
-		rv[i] = byte(c) << 1
-	}
-	i, err := strconv.Atoi(a.SSID)
-	if err != nil {
-		i = 0
-	}
-	rv[6] = ssidMask | (byte(i) << 1)
-	return rv
+// SetMarkRepeated sets whether the last path address with its
+// has-been-repeated bit set is marked with a *, as in TNC2 format.
+func (d *Decoder) SetMarkRepeated(to bool) {
+	d.markRepeated = to
 }
 
-func toAX25(m aprs.Frame, smask, dmask byte) []byte {
-	b := &bytes.Buffer{}
-	b.Write(addressEncode(m.Dest, dmask))
-	mask := smask
-	if len(m.Path) == 0 {
-		mask |= 1
-	}
-	b.Write(addressEncode(m.Source, smask))
-	for i, p := range m.Path {
-		mask = clearSSIDMask
-		if i == len(m.Path)-1 {
-			mask |= 1
-		}
-		b.Write(addressEncode(p, mask))
-	}
-	b.Write([]byte{3, 0xf0})
-	b.Write([]byte(m.Body))
-	return b.Bytes()
+// NewDecoder gets a new decoder over this reader.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{k: NewKISSDecoder(r)}
 }
 
 // EncodeAPRSCommand encodes an APRS command to an AX.25 frame.
 func EncodeAPRSCommand(m aprs.Frame) []byte {
-	return toAX25(m, setSSIDMask, clearSSIDMask)
+	return FrameFromAPRS(m, true).Encode()
 }
 
 // EncodeAPRSResponse encodes an APRS response to an AX.25 frame.
 func EncodeAPRSResponse(m aprs.Frame) []byte {
-	return toAX25(m, clearSSIDMask, setSSIDMask)
+	return FrameFromAPRS(m, false).Encode()
 }
@@ -0,0 +1,190 @@
+package ax25
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// ErrFX25TooLong is returned when a frame won't fit in any FX.25
+// codeblock with the requested number of check bytes.
+var ErrFX25TooLong = errors.New("frame too long for FX.25")
+
+// ErrFX25Uncorrectable is returned for an FX.25 codeblock with too
+// many errors to correct.
+var ErrFX25Uncorrectable = errors.New("uncorrectable FX.25 codeblock")
+
+// fx25TagTolerance is how many bits of a correlation tag may be
+// wrong for it to still be recognized.
+const fx25TagTolerance = 8
+
+// An fx25Mode is an FX.25 codeblock format, identified by its
+// correlation tag.
+type fx25Mode struct {
+	tag uint64
+	// n is the codeblock size and k the data size within it.
+	n, k int
+}
+
+// fx25Modes are the defined codeblock formats, smallest first for
+// each number of check bytes.
+var fx25Modes = []fx25Mode{
+	{0x8f056eb4369660ee, 48, 32},
+	{0xc7dc0508f3d9b09e, 80, 64},
+	{0x26ff60a600cc8fde, 144, 128},
+	{0xb74db7df8a532f3e, 255, 239},
+	{0xdbf869bd2dbb1776, 64, 32},
+	{0x1eb7b9cdbc09c00e, 96, 64},
+	{0xff94dc634f1cff4e, 160, 128},
+	{0x6e260b1ac5835fae, 255, 223},
+	{0x4a4abec4a724b796, 128, 64},
+	{0xab69db6a543188d6, 192, 128},
+	{0x3adb0c13deae2836, 255, 191},
+}
+
+var fx25Codes = map[int]*rsCode{
+	16: newRSCode(16, 1),
+	32: newRSCode(32, 1),
+	64: newRSCode(64, 1),
+}
+
+func (m fx25Mode) check() int {
+	return m.n - m.k
+}
+
+// fx25ModeFor finds the codeblock format for a correlation tag,
+// allowing a few bit errors.
+func fx25ModeFor(tag uint64) (fx25Mode, bool) {
+	for _, m := range fx25Modes {
+		if bits.OnesCount64(m.tag^tag) <= fx25TagTolerance {
+			return m, true
+		}
+	}
+	return fx25Mode{}, false
+}
+
+// packBits packs bits (one per byte) into bytes, least significant
+// bit first as they're sent.
+func packBits(b []byte) []byte {
+	rv := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		rv[i/8] |= (bit & 1) << uint(i%8)
+	}
+	return rv
+}
+
+// unpackBits is the inverse of packBits.
+func unpackBits(b []byte) []byte {
+	rv := make([]byte, 0, len(b)*8)
+	for _, c := range b {
+		for i := uint(0); i < 8; i++ {
+			rv = append(rv, c>>i&1)
+		}
+	}
+	return rv
+}
+
+// FX25Encode wraps an AX.25 frame (without FCS) in the smallest FX.25
+// codeblock with the given number of check bytes (16, 32 or 64),
+// returning the correlation tag and codeblock as sent, before NRZI
+// encoding.
+func FX25Encode(frame []byte, check int) ([]byte, error) {
+	if fx25Codes[check] == nil {
+		return nil, errors.New("FX.25 check bytes must be 16, 32 or 64")
+	}
+	e := NewHDLCEncoder(1, 1)
+	e.SetNRZI(false)
+	hdlc := e.Encode(frame)
+
+	for _, m := range fx25Modes {
+		if m.check() != check || len(hdlc) > m.k*8 {
+			continue
+		}
+		// Fill out the data with more flags.
+		for len(hdlc) < m.k*8 {
+			hdlc = append(hdlc, e.Flags(1)...)
+		}
+		data := packBits(hdlc[:m.k*8])
+
+		rv := make([]byte, 8, 8+m.n)
+		binary.LittleEndian.PutUint64(rv, m.tag)
+		rv = append(rv, data...)
+		return append(rv, fx25Codes[check].encode(data)...), nil
+	}
+	return nil, ErrFX25TooLong
+}
+
+// fx25Frame corrects a codeblock and finds the AX.25 frame in it,
+// returning the frame and how many bytes were corrected.
+func fx25Frame(m fx25Mode, block []byte) ([]byte, int, error) {
+	block = append([]byte{}, block...)
+	corrected, err := fx25Codes[m.check()].decode(block)
+	if err != nil {
+		return nil, 0, ErrFX25Uncorrectable
+	}
+	d := NewHDLCDecoder()
+	d.SetNRZI(false)
+	for _, b := range unpackBits(block[:m.k]) {
+		f, err := d.Bit(b)
+		if f != nil && err == nil {
+			return f, corrected, nil
+		}
+	}
+	return nil, corrected, ErrFX25Uncorrectable
+}
+
+// FX25Decode decodes an FX.25 correlation tag and codeblock as
+// received (after NRZI decoding), returning the AX.25 frame (without
+// FCS) and how many bytes were corrected.
+func FX25Decode(b []byte) ([]byte, int, error) {
+	if len(b) < 8 {
+		return nil, 0, ErrFX25Uncorrectable
+	}
+	m, ok := fx25ModeFor(binary.LittleEndian.Uint64(b))
+	if !ok || len(b) < 8+m.n {
+		return nil, 0, ErrFX25Uncorrectable
+	}
+	return fx25Frame(m, b[8:8+m.n])
+}
+
+// EncodeFX25 returns the bitstream for a frame wrapped in an FX.25
+// codeblock with the given number of check bytes, between flags.
+// Receivers without FX.25 can still decode the frame inside.
+func (e *HDLCEncoder) EncodeFX25(frame []byte, check int) ([]byte, error) {
+	block, err := FX25Encode(frame, check)
+	if err != nil {
+		return nil, err
+	}
+	out := e.flags(nil, e.preamble)
+	for _, b := range unpackBits(block) {
+		out = e.emit(out, b)
+	}
+	return e.flags(out, e.postamble), nil
+}
+
+// fx25Receiver watches decoded bits for FX.25 codeblocks.
+type fx25Receiver struct {
+	tag   uint64
+	mode  fx25Mode
+	bits  []byte
+	block bool
+}
+
+// bit processes the next bit, reporting done with the codeblock's
+// result when one is complete.
+func (r *fx25Receiver) bit(b byte) (frame []byte, corrected int, done bool, err error) {
+	if !r.block {
+		r.tag = r.tag>>1 | uint64(b)<<63
+		if m, ok := fx25ModeFor(r.tag); ok {
+			r.mode, r.block, r.bits = m, true, r.bits[:0]
+		}
+		return nil, 0, false, nil
+	}
+	r.bits = append(r.bits, b)
+	if len(r.bits) < r.mode.n*8 {
+		return nil, 0, false, nil
+	}
+	r.block, r.tag = false, 0
+	frame, corrected, err = fx25Frame(r.mode, packBits(r.bits))
+	return frame, corrected, true, err
+}
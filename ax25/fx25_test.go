@@ -0,0 +1,232 @@
+package ax25
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/dustin/go-aprs"
+)
+
+func TestFX25RoundTrip(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	for _, c := range []struct{ check, n int }{{16, 144}, {32, 160}, {64, 192}} {
+		b, err := FX25Encode(frame, c.check)
+		if err != nil {
+			t.Fatalf("Error encoding with %v check bytes: %v", c.check, err)
+		}
+		if len(b) != 8+c.n {
+			t.Errorf("Expected a %v byte codeblock for %v check bytes, got %v",
+				c.n, c.check, len(b)-8)
+		}
+		got, corrected, err := FX25Decode(b)
+		if err != nil || corrected != 0 || !bytes.Equal(got, frame) {
+			t.Errorf("%v check bytes: got % x, %v corrected, %v", c.check, got, corrected, err)
+		}
+	}
+}
+
+func TestFX25Errors(t *testing.T) {
+	if _, err := FX25Encode(make([]byte, 300), 16); err != ErrFX25TooLong {
+		t.Errorf("Expected ErrFX25TooLong, got %v", err)
+	}
+	if _, err := FX25Encode(nil, 17); err == nil {
+		t.Errorf("Expected an error with 17 check bytes")
+	}
+	if _, _, err := FX25Decode([]byte("short")); err != ErrFX25Uncorrectable {
+		t.Errorf("Expected ErrFX25Uncorrectable on a short block, got %v", err)
+	}
+}
+
+func TestFX25Corrects(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	b, err := FX25Encode(frame, 16)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+
+	for _, errs := range []int{1, 5, 8} {
+		bad := append([]byte{}, b...)
+		for i := 0; i < errs; i++ {
+			bad[8+i*7] ^= 0x5a
+		}
+		got, corrected, err := FX25Decode(bad)
+		if err != nil || !bytes.Equal(got, frame) {
+			t.Fatalf("%v errors: got % x, %v", errs, got, err)
+		}
+		if corrected != errs {
+			t.Errorf("Expected %v bytes corrected, got %v", errs, corrected)
+		}
+	}
+
+	bad := append([]byte{}, b...)
+	for i := 0; i < 9; i++ {
+		bad[8+i*7] ^= 0x5a
+	}
+	if _, _, err := FX25Decode(bad); err != ErrFX25Uncorrectable {
+		t.Errorf("Expected ErrFX25Uncorrectable with 9 errors, got %v", err)
+	}
+}
+
+func TestFX25TagTolerance(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	b, err := FX25Encode(frame, 32)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	tag := binary.LittleEndian.Uint64(b)
+	binary.LittleEndian.PutUint64(b, tag^0x0101010101010101)
+	if got, _, err := FX25Decode(b); err != nil || !bytes.Equal(got, frame) {
+		t.Errorf("Expected a tag with 8 bad bits to work, got % x, %v", got, err)
+	}
+	binary.LittleEndian.PutUint64(b, tag^0x0303030303030303)
+	if _, _, err := FX25Decode(b); err == nil {
+		t.Errorf("Expected a tag with 16 bad bits to fail")
+	}
+}
+
+func TestFX25Stream(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	e := NewHDLCEncoder(4, 2)
+	var bits []byte
+	for _, check := range []int{16, 32, 64} {
+		b, err := e.EncodeFX25(frame, check)
+		if err != nil {
+			t.Fatalf("Error encoding with %v check bytes: %v", check, err)
+		}
+		bits = append(bits, b...)
+	}
+	plain := e.Encode(frame)
+	bits = append(bits, plain...)
+
+	d := NewHDLCDecoder()
+	var got [][]byte
+	var corrected []int
+	for _, b := range bits {
+		if f, err := d.Bit(b); f != nil && err == nil {
+			got = append(got, f)
+			corrected = append(corrected, d.Corrected())
+		}
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected each frame once, got %v frames", len(got))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], frame) {
+			t.Errorf("Frame %v was % x", i, got[i])
+		}
+	}
+	if want := []int{0, 0, 0, -1}; !equalInts(corrected, want) {
+		t.Errorf("Expected corrected %v, got %v", want, corrected)
+	}
+}
+
+func TestFX25StreamCorrects(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	e := NewHDLCEncoder(4, 2)
+	e.SetNRZI(false)
+	bits, err := e.EncodeFX25(frame, 16)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	// Damage three bytes of the frame itself, which a plain
+	// receiver can't recover from.
+	for _, i := range []int{100, 180, 260} {
+		bits[32+64+i] ^= 1
+	}
+
+	d := NewHDLCDecoder()
+	d.SetNRZI(false)
+	got, bad := d.Decode(bits)
+	if len(got) != 1 || !bytes.Equal(got[0], frame) || len(bad) != 0 {
+		t.Fatalf("Expected the corrected frame, got % x and bad % x", got, bad)
+	}
+	if d.Corrected() != 3 {
+		t.Errorf("Expected 3 bytes corrected, got %v", d.Corrected())
+	}
+
+	// Without FX.25 the frame is lost.
+	plain := &HDLCDecoder{}
+	for _, b := range bits[32+64:] {
+		if f, err := plain.hdlcBit(b); f != nil && err == nil {
+			t.Fatalf("Expected the damaged frame to fail plain HDLC")
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fx25Vector is "KG6HWF>APRS:>hi" in a 48 byte FX.25 codeblock with
+// 16 check bytes.  It's checked against the FX.25 specification
+// below without using the package's encoder or Reed-Solomon tables.
+var fx25Vector = []byte{
+	// Tag_04, sent least significant byte first.
+	0xee, 0x60, 0x96, 0x36, 0xb4, 0x6e, 0x05, 0x8f,
+	// The HDLC frame, bit stuffed and padded with flags.
+	0x7e, 0x82, 0xa0, 0xa4, 0xa6, 0x40, 0x40, 0xe0,
+	0x96, 0x8e, 0x6c, 0x90, 0xae, 0x8c, 0x61, 0x03,
+	0xf0, 0x3e, 0xd0, 0xd2, 0xb8, 0xc7, 0xfc, 0xfc,
+	0xfc, 0xfc, 0xfc, 0xfc, 0xfc, 0xfc, 0xfc, 0xfc,
+	// RS(48,32) check bytes.
+	0xda, 0x94, 0x2f, 0xba, 0x06, 0x6c, 0xbb, 0x34,
+	0xcf, 0x7c, 0x48, 0x61, 0x60, 0x68, 0x78, 0x61,
+}
+
+// slowGFMul multiplies in GF(2^8) modulo x^8+x^4+x^3+x^2+1 a bit at a
+// time.
+func slowGFMul(a, b byte) byte {
+	var p byte
+	for ; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a&0x80 != 0
+		a <<= 1
+		if carry {
+			a ^= 0x1d
+		}
+	}
+	return p
+}
+
+func TestFX25Vector(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame("KG6HWF>APRS:>hi"))
+	got, err := FX25Encode(frame, 16)
+	if err != nil || !bytes.Equal(got, fx25Vector) {
+		t.Fatalf("Encoded as % x/%v, want % x", got, err, fx25Vector)
+	}
+	dec, corrected, err := FX25Decode(fx25Vector)
+	if err != nil || corrected != 0 || !bytes.Equal(dec, frame) {
+		t.Fatalf("Decoded as % x, %v corrected, %v", dec, corrected, err)
+	}
+
+	if tag := binary.LittleEndian.Uint64(fx25Vector); tag != 0x8F056EB4369660EE {
+		t.Errorf("Tag is %016X, want Tag_04 8F056EB4369660EE", tag)
+	}
+
+	// The codeword, data first and highest degree first, must have
+	// the generator's roots alpha^1 to alpha^16 (alpha = 2), and
+	// nothing either side of them.
+	codeword := fx25Vector[8:]
+	root := byte(1)
+	for i := 0; i <= 17; i++ {
+		var s byte
+		for _, c := range codeword {
+			s = slowGFMul(s, root) ^ c
+		}
+		if isRoot := i >= 1 && i <= 16; (s == 0) != isRoot {
+			t.Errorf("Syndrome at alpha^%v is %02x", i, s)
+		}
+		root = slowGFMul(root, 2)
+	}
+}
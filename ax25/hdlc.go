@@ -0,0 +1,288 @@
+package ax25
+
+import "errors"
+
+// ErrBadFCS is returned for an HDLC frame whose FCS doesn't match
+// its content.
+var ErrBadFCS = errors.New("bad FCS")
+
+// hdlcFlag is the HDLC flag byte that separates frames.
+const hdlcFlag = 0x7e
+
+// maxHDLCFrame is the longest frame the HDLC decoder collects, FCS
+// included.
+const maxHDLCFrame = 2048
+
+// minHDLCFrame is the shortest AX.25 frame with its FCS: two
+// addresses, a control field and the FCS.
+const minHDLCFrame = 14 + 1 + 2
+
+// FCS computes the CCITT FCS-16 used by AX.25 (as in X.25, reflected
+// polynomial 0x8408).
+func FCS(b []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, c := range b {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+// AppendFCS returns the frame with its FCS appended, low byte first
+// as it's sent.
+func AppendFCS(frame []byte) []byte {
+	fcs := FCS(frame)
+	return append(append([]byte{}, frame...), byte(fcs), byte(fcs>>8))
+}
+
+// CheckFCS reports whether the last two bytes of b are the FCS of
+// the rest.
+func CheckFCS(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	fcs := FCS(b[:len(b)-2])
+	return b[len(b)-2] == byte(fcs) && b[len(b)-1] == byte(fcs>>8)
+}
+
+// HDLCEncoder turns AX.25 frames into HDLC bitstreams.  Bitstreams
+// hold one bit per byte (0 or 1), in the order they're sent.
+type HDLCEncoder struct {
+	preamble, postamble int
+	nrzi                bool
+	level               byte
+}
+
+// NewHDLCEncoder gets a new HDLC encoder sending the given number of
+// flags before and after each frame.  Output is NRZI encoded.
+func NewHDLCEncoder(preamble, postamble int) *HDLCEncoder {
+	if preamble < 1 {
+		preamble = 1
+	}
+	if postamble < 1 {
+		postamble = 1
+	}
+	return &HDLCEncoder{preamble: preamble, postamble: postamble, nrzi: true}
+}
+
+// SetNRZI sets whether output is NRZI encoded (on by default).  With
+// it off, the encoder emits the stuffed data bits themselves.
+func (e *HDLCEncoder) SetNRZI(to bool) {
+	e.nrzi = to
+}
+
+func (e *HDLCEncoder) emit(out []byte, bit byte) []byte {
+	if !e.nrzi {
+		return append(out, bit)
+	}
+	if bit == 0 {
+		e.level ^= 1
+	}
+	return append(out, e.level)
+}
+
+func (e *HDLCEncoder) flags(out []byte, n int) []byte {
+	for ; n > 0; n-- {
+		for i := uint(0); i < 8; i++ {
+			out = e.emit(out, hdlcFlag>>i&1)
+		}
+	}
+	return out
+}
+
+// Flags returns the bitstream for n flags, such as to fill time
+// before or between frames.
+func (e *HDLCEncoder) Flags(n int) []byte {
+	return e.flags(nil, n)
+}
+
+// Encode returns the bitstream for a frame (without its FCS, which
+// is added), between flags.  The NRZI line level carries on from the
+// previous frame.
+func (e *HDLCEncoder) Encode(frame []byte) []byte {
+	out := make([]byte, 0, (len(frame)+2+e.preamble+e.postamble)*10)
+	out = e.flags(out, e.preamble)
+	ones := 0
+	for _, c := range AppendFCS(frame) {
+		for i := uint(0); i < 8; i++ {
+			bit := c >> i & 1
+			out = e.emit(out, bit)
+			if bit == 0 {
+				ones = 0
+				continue
+			}
+			ones++
+			if ones == 5 {
+				out = e.emit(out, 0)
+				ones = 0
+			}
+		}
+	}
+	return e.flags(out, e.postamble)
+}
+
+// HDLCDecoder finds AX.25 frames in HDLC bitstreams, such as those
+// from a demodulator or a raw capture.
+type HDLCDecoder struct {
+	nrzi    bool
+	level   byte
+	ones    int
+	inFrame bool
+	cur     byte
+	nbits   int
+	buf     []byte
+
+	fx        fx25Receiver
+	held      []byte
+	corrected int
+}
+
+// NewHDLCDecoder gets a new HDLC decoder expecting NRZI encoded
+// input.
+func NewHDLCDecoder() *HDLCDecoder {
+	return &HDLCDecoder{nrzi: true, corrected: -1}
+}
+
+// SetNRZI sets whether input is NRZI encoded (on by default).
+func (d *HDLCDecoder) SetNRZI(to bool) {
+	d.nrzi = to
+}
+
+func (d *HDLCDecoder) reset() {
+	d.cur, d.nbits, d.buf = 0, 0, d.buf[:0]
+}
+
+func (d *HDLCDecoder) add(bit byte) {
+	if !d.inFrame {
+		return
+	}
+	d.cur |= bit << uint(d.nbits%8)
+	d.nbits++
+	if d.nbits%8 == 0 {
+		d.buf = append(d.buf, d.cur)
+		d.cur = 0
+		if len(d.buf) > maxHDLCFrame+1 {
+			// Too long to be a frame; wait for the next flag.
+			d.inFrame = false
+			d.reset()
+		}
+	}
+}
+
+// flag finishes the frame before a flag, if there's one.
+func (d *HDLCDecoder) flag() ([]byte, error) {
+	// The flag's leading zero and six ones have been collected as
+	// data.
+	n := d.nbits - 7
+	was := d.inFrame
+	buf := d.buf
+	d.inFrame = true
+	d.reset()
+	if !was || n%8 != 0 || n/8 < minHDLCFrame {
+		return nil, nil
+	}
+	b := append([]byte{}, buf[:n/8]...)
+	if !CheckFCS(b) {
+		return b[:len(b)-2], ErrBadFCS
+	}
+	return b[:len(b)-2], nil
+}
+
+// Bit processes the next bit (0 or 1) of input.  When it completes a
+// frame, the frame is returned without its FCS; if the FCS didn't
+// match, ErrBadFCS is returned with it.  Otherwise it returns nil,
+// nil.
+//
+// Frames sent in FX.25 codeblocks are corrected, and only returned
+// once even though the frame inside is also plain HDLC.
+func (d *HDLCDecoder) Bit(b byte) ([]byte, error) {
+	b &= 1
+	if d.nrzi {
+		level := b
+		b = 0
+		if level == d.level {
+			b = 1
+		}
+		d.level = level
+	}
+
+	fxFrame, corrected, done, fxErr := d.fx.bit(b)
+	f, err := d.hdlcBit(b)
+	switch {
+	case d.fx.block:
+		// Hold on to frames inside a codeblock in case it
+		// can't be corrected.
+		if f != nil && err == nil {
+			d.held = f
+		}
+		return nil, nil
+	case done:
+		held := d.held
+		d.held = nil
+		if fxErr == nil {
+			d.corrected = corrected
+			return fxFrame, nil
+		}
+		if held != nil {
+			d.corrected = -1
+			return held, nil
+		}
+	}
+	if f != nil {
+		d.corrected = -1
+	}
+	return f, err
+}
+
+// Corrected returns how many bytes FX.25 corrected in the last frame
+// returned, or -1 if it wasn't received in an FX.25 codeblock.
+func (d *HDLCDecoder) Corrected() int {
+	return d.corrected
+}
+
+func (d *HDLCDecoder) hdlcBit(b byte) ([]byte, error) {
+	if b == 1 {
+		d.ones++
+		if d.ones > 6 {
+			// Abort, or an idle line.
+			d.inFrame = false
+			d.reset()
+			return nil, nil
+		}
+		d.add(1)
+		return nil, nil
+	}
+
+	ones := d.ones
+	d.ones = 0
+	switch ones {
+	case 6:
+		return d.flag()
+	case 5:
+		// A stuffed zero.
+		return nil, nil
+	}
+	d.add(0)
+	return nil, nil
+}
+
+// Decode processes a bitstream, returning the good frames and those
+// with bad FCSes separately.
+func (d *HDLCDecoder) Decode(bits []byte) (frames, bad [][]byte) {
+	for _, b := range bits {
+		f, err := d.Bit(b)
+		switch {
+		case err == ErrBadFCS:
+			bad = append(bad, f)
+		case f != nil:
+			frames = append(frames, f)
+		}
+	}
+	return frames, bad
+}
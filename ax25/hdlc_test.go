@@ -0,0 +1,138 @@
+package ax25
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dustin/go-aprs"
+)
+
+func TestFCS(t *testing.T) {
+	if got := FCS([]byte("123456789")); got != 0x906e {
+		t.Fatalf("Expected FCS 0x906e, got %#04x", got)
+	}
+	b := AppendFCS([]byte("123456789"))
+	if !bytes.Equal(b[9:], []byte{0x6e, 0x90}) {
+		t.Fatalf("Expected FCS low byte first, got % x", b[9:])
+	}
+	if !CheckFCS(b) {
+		t.Fatalf("FCS check failed on % x", b)
+	}
+	b[0] ^= 1
+	if CheckFCS(b) {
+		t.Fatalf("FCS check passed on corrupted % x", b)
+	}
+	if CheckFCS([]byte{1}) {
+		t.Fatalf("FCS check passed on a single byte")
+	}
+}
+
+func TestHDLCRoundTrip(t *testing.T) {
+	frames := [][]byte{
+		EncodeAPRSCommand(aprs.ParseFrame(christmasMsg)),
+		// Lots of ones to stuff, and some flags in the data.
+		append(EncodeAPRSCommand(aprs.ParseFrame("KG6HWF>APRS:x")),
+			0xff, 0xff, 0x7e, 0x7e, 0xff, 0x00, 0xfe),
+	}
+
+	for _, nrzi := range []bool{true, false} {
+		e := NewHDLCEncoder(4, 2)
+		e.SetNRZI(nrzi)
+		var bits []byte
+		for _, f := range frames {
+			bits = append(bits, e.Encode(f)...)
+		}
+
+		d := NewHDLCDecoder()
+		d.SetNRZI(nrzi)
+		got, bad := d.Decode(bits)
+		if len(bad) != 0 {
+			t.Errorf("nrzi=%v: unexpected bad frames: %x", nrzi, bad)
+		}
+		if len(got) != len(frames) {
+			t.Fatalf("nrzi=%v: expected %v frames, got %v", nrzi, len(frames), len(got))
+		}
+		for i := range frames {
+			if !bytes.Equal(got[i], frames[i]) {
+				t.Errorf("nrzi=%v: frame %v was\n% x\nexpected\n% x",
+					nrzi, i, got[i], frames[i])
+			}
+		}
+	}
+}
+
+func TestHDLCStuffing(t *testing.T) {
+	e := NewHDLCEncoder(1, 1)
+	e.SetNRZI(false)
+	bits := e.Encode(bytes.Repeat([]byte{0xff}, 20))
+	// Outside the flags there should never be six ones in a row.
+	ones := 0
+	for _, b := range bits[8 : len(bits)-8] {
+		if b == 1 {
+			ones++
+			if ones > 5 {
+				t.Fatalf("Found unstuffed ones in %v", bits)
+			}
+		} else {
+			ones = 0
+		}
+	}
+}
+
+func TestHDLCBadFCS(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	e := NewHDLCEncoder(2, 1)
+	bits := e.Encode(frame)
+	// Flip a level in the middle of the frame.
+	bits[len(bits)/2] ^= 1
+	bits = append(bits, e.Encode(frame)...)
+
+	got, bad := NewHDLCDecoder().Decode(bits)
+	if len(got) != 1 || !bytes.Equal(got[0], frame) {
+		t.Errorf("Expected the second frame to decode, got %x", got)
+	}
+	if len(bad) != 1 {
+		t.Errorf("Expected one bad frame, got %x", bad)
+	}
+}
+
+func TestHDLCAbort(t *testing.T) {
+	frame := EncodeAPRSCommand(aprs.ParseFrame(christmasMsg))
+	e := NewHDLCEncoder(1, 1)
+	e.SetNRZI(false)
+	bits := e.Encode(frame)
+	// Abort the first frame partway through with seven ones.
+	aborted := append(append([]byte{}, bits[:len(bits)/2]...),
+		1, 1, 1, 1, 1, 1, 1, 1)
+	bits = append(aborted, bits...)
+
+	d := NewHDLCDecoder()
+	d.SetNRZI(false)
+	got, bad := d.Decode(bits)
+	if len(got) != 1 || len(bad) != 0 {
+		t.Fatalf("Expected just the complete frame, got %x and bad %x", got, bad)
+	}
+}
+
+func TestHDLCIgnoresNoise(t *testing.T) {
+	d := NewHDLCDecoder()
+	// Idle flags and short garbage between them aren't frames.
+	e := NewHDLCEncoder(10, 1)
+	e.SetNRZI(false)
+	bits := e.Encode(nil)
+	d.SetNRZI(false)
+	if got, bad := d.Decode(bits); len(got) != 0 || len(bad) != 0 {
+		t.Fatalf("Expected nothing, got %x and bad %x", got, bad)
+	}
+}
+
+func TestHDLCFlags(t *testing.T) {
+	e := NewHDLCEncoder(1, 1)
+	e.SetNRZI(false)
+	if got := e.Flags(2); !bytes.Equal(got, []byte{0, 1, 1, 1, 1, 1, 1, 0, 0, 1, 1, 1, 1, 1, 1, 0}) {
+		t.Errorf("Expected two flags, got %v", got)
+	}
+	if got := e.Flags(0); len(got) != 0 {
+		t.Errorf("Expected no flags, got %v", got)
+	}
+}
@@ -0,0 +1,340 @@
+package ax25
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/dustin/go-aprs"
+)
+
+// IL2PSync is the 24 bit sync word sent before each IL2P frame.
+const IL2PSync = 0xf15e48
+
+// ErrIL2PTooLong is returned when a frame's payload won't fit in an
+// IL2P frame.
+var ErrIL2PTooLong = errors.New("frame too long for IL2P")
+
+// ErrIL2PUncorrectable is returned for an IL2P header or payload
+// block with too many errors to correct.
+var ErrIL2PUncorrectable = errors.New("uncorrectable IL2P frame")
+
+var errIL2PSync = fmt.Errorf("%w: no IL2P sync word", ErrMalformed)
+var errIL2PHeader = fmt.Errorf("%w: bad IL2P header", ErrMalformed)
+
+const (
+	il2pHeaderSize  = 13
+	il2pHeaderCheck = 2
+	il2pMaxPayload  = 1023
+	// il2pSyncTolerance is how many bits of the sync word may be
+	// wrong for it to still be recognized.
+	il2pSyncTolerance = 1
+)
+
+var il2pCodes = map[int]*rsCode{
+	2:  newRSCode(2, 0),
+	4:  newRSCode(4, 0),
+	6:  newRSCode(6, 0),
+	8:  newRSCode(8, 0),
+	16: newRSCode(16, 0),
+}
+
+// il2pPIDs maps IL2P's 4 bit PIDs to AX.25 PIDs.  0 and 1 mark S
+// and U frames, which have none.
+var il2pPIDs = []byte{0, 0, 0x20, PIDX25, 0x06, 0x07, 0x08,
+	0xc3, 0xc4, 0xca, 0xcb, PIDIP, PIDARP, 0xce, PIDNetROM, PIDNoL3}
+
+// il2pSTypes and il2pUTypes are the frame types in the order of
+// their IL2P opcodes.
+var il2pSTypes = []byte{RR, RNR, REJ, SREJ}
+var il2pUTypes = []byte{SABM, DISC, DM, UA, FRMR, UI, XID, TEST}
+
+func indexByte(b []byte, c byte) int {
+	for i, x := range b {
+		if x == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// il2pLFSR scrambles or descrambles a block with the x^9+x^4+1 LFSR,
+// restarted for each block.  Bits go most significant first.
+func il2pLFSR(in []byte, scramble bool) []byte {
+	out := make([]byte, len(in))
+	state := 0x1f0
+	for i, c := range in {
+		for mask := byte(0x80); mask != 0; mask >>= 1 {
+			bit := 0
+			if c&mask != 0 {
+				bit = 1
+			}
+			o := bit ^ state&1
+			// The register is fed with the scrambled bits.
+			s := bit
+			if scramble {
+				s = o
+			}
+			state = (state>>1 | s<<8) ^ s<<3
+			if o != 0 {
+				out[i] |= mask
+			}
+		}
+	}
+	return out
+}
+
+// il2pBlocks returns the sizes of the payload blocks carrying n
+// bytes, and how many check bytes each gets.  Larger blocks come
+// first.
+func il2pBlocks(n int, maxFEC bool) (sizes []int, check int) {
+	if n == 0 {
+		return nil, 0
+	}
+	per := 247
+	if maxFEC {
+		per = 239
+	}
+	count := (n + per - 1) / per
+	small := n / count
+	for i := 0; i < count; i++ {
+		sizes = append(sizes, small)
+		if i < n-count*small {
+			sizes[i]++
+		}
+	}
+	switch {
+	case maxFEC:
+		check = 16
+	case small <= 61:
+		check = 2
+	case small <= 123:
+		check = 4
+	case small <= 185:
+		check = 6
+	default:
+		check = 8
+	}
+	return sizes, check
+}
+
+// Header fields other than the callsigns are spread over bit 6 or 7
+// of consecutive header bytes, most significant bit first.
+func il2pSetField(h []byte, bit uint, last, width, v int) {
+	for i := 0; i < width; i++ {
+		if v>>uint(i)&1 != 0 {
+			h[last-i] |= 1 << bit
+		}
+	}
+}
+
+func il2pField(h []byte, bit uint, last, width int) int {
+	v := 0
+	for i := 0; i < width; i++ {
+		v |= int(h[last-i]>>bit&1) << uint(i)
+	}
+	return v
+}
+
+func il2pPID(pid byte) (int, bool) {
+	i := indexByte(il2pPIDs[2:], pid)
+	return i + 2, i >= 0
+}
+
+func il2pCall(call string) bool {
+	if len(call) == 0 || len(call) > 6 {
+		return false
+	}
+	for _, c := range call {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// il2pControl translates the frame type to IL2P's UI flag, PID and
+// control fields, if it can be.  IL2P has a single command/response
+// bit, so AX.25 v1 frames without distinct C bits become responses,
+// and only modulo 8 sequence numbers.
+func il2pControl(f Frame) (ui, pid, control int, ok bool) {
+	if f.extended() {
+		return 0, 0, 0, false
+	}
+	pf, cmd := 0, 0
+	if f.PF() {
+		pf = 1
+	}
+	if f.IsCommand() {
+		cmd = 1
+	}
+
+	switch f.Kind() {
+	case IFrame:
+		pid, ok = il2pPID(f.PID)
+		return 0, pid, pf<<6 | f.NR()<<3 | f.NS(), ok
+	case SFrame:
+		t := indexByte(il2pSTypes, f.Type())
+		return 0, 0, pf<<6 | f.NR()<<3 | cmd<<2 | t, t >= 0
+	}
+	t := indexByte(il2pUTypes, f.Type())
+	if t < 0 {
+		return 0, 0, 0, false
+	}
+	control = pf<<6 | t<<3 | cmd<<2
+	if f.Type() == UI {
+		pid, ok = il2pPID(f.PID)
+		return 1, pid, control, ok
+	}
+	return 0, 1, control, true
+}
+
+// il2pHeader builds the header and payload for a frame.  Frames that
+// can be translated use a type 1 header, and others are sent whole
+// as the payload after a type 0 header.
+func il2pHeader(f Frame, maxFEC bool) (header, payload []byte) {
+	header = make([]byte, il2pHeaderSize)
+	ui, pid, control, ok := il2pControl(f)
+	if ok && len(f.Path) == 0 && il2pCall(f.Dest.Call) && il2pCall(f.Source.Call) {
+		for i := 0; i < 6; i++ {
+			if i < len(f.Dest.Call) {
+				header[i] = f.Dest.Call[i] - 0x20
+			}
+			if i < len(f.Source.Call) {
+				header[6+i] = f.Source.Call[i] - 0x20
+			}
+		}
+		header[12] = byte(f.Dest.SSID&0xf)<<4 | byte(f.Source.SSID&0xf)
+		il2pSetField(header, 6, 0, 1, ui)
+		il2pSetField(header, 6, 4, 4, pid)
+		il2pSetField(header, 6, 11, 7, control)
+		il2pSetField(header, 7, 1, 1, 1)
+		payload = f.Info
+	} else {
+		payload = f.Encode()
+	}
+	if maxFEC {
+		il2pSetField(header, 7, 0, 1, 1)
+	}
+	il2pSetField(header, 7, 11, 10, len(payload)&il2pMaxPayload)
+	return header, payload
+}
+
+// EncodeIL2P encodes a frame as IL2P, returning the sync word, header
+// and payload blocks as sent.  With maxFEC, each payload block gets
+// 16 check bytes rather than 2 to 8 depending on its size.
+func EncodeIL2P(f Frame, maxFEC bool) ([]byte, error) {
+	header, payload := il2pHeader(f, maxFEC)
+	if len(payload) > il2pMaxPayload {
+		return nil, ErrIL2PTooLong
+	}
+
+	rv := []byte{IL2PSync >> 16, IL2PSync >> 8 & 0xff, IL2PSync & 0xff}
+	block := il2pLFSR(header, true)
+	rv = append(rv, block...)
+	rv = append(rv, il2pCodes[il2pHeaderCheck].encode(block)...)
+
+	sizes, check := il2pBlocks(len(payload), maxFEC)
+	for _, n := range sizes {
+		block = il2pLFSR(payload[:n], true)
+		rv = append(rv, block...)
+		rv = append(rv, il2pCodes[check].encode(block)...)
+		payload = payload[n:]
+	}
+	return rv, nil
+}
+
+// EncodeIL2PAPRS encodes an APRS command as IL2P.
+func EncodeIL2PAPRS(m aprs.Frame, maxFEC bool) ([]byte, error) {
+	return EncodeIL2P(FrameFromAPRS(m, true), maxFEC)
+}
+
+// il2pBlock corrects and descrambles the next block of b with the
+// given number of check bytes.
+func il2pBlock(b []byte, n, check int) (data []byte, corrected int, err error) {
+	if len(b) < n+check {
+		return nil, 0, errTruncatedMsg
+	}
+	block := append([]byte{}, b[:n+check]...)
+	corrected, err = il2pCodes[check].decode(block)
+	if err != nil {
+		return nil, 0, ErrIL2PUncorrectable
+	}
+	return il2pLFSR(block[:n], false), corrected, nil
+}
+
+// il2pFrame rebuilds a frame from a type 1 header and its payload.
+func il2pFrame(h, payload []byte) (f Frame, err error) {
+	call := func(b []byte) string {
+		s := make([]byte, 0, 6)
+		for _, c := range b {
+			if c&0x3f != 0 {
+				s = append(s, c&0x3f+0x20)
+			}
+		}
+		return string(s)
+	}
+	f.Dest = Address{Call: call(h[0:6]), SSID: int(h[12] >> 4)}
+	f.Source = Address{Call: call(h[6:12]), SSID: int(h[12] & 0xf)}
+
+	ui := il2pField(h, 6, 0, 1)
+	pid := il2pField(h, 6, 4, 4)
+	control := il2pField(h, 6, 11, 7)
+	pf, cmd := control&0x40 != 0, control&4 != 0
+	switch {
+	case ui == 1 || pid >= 2:
+		if pid < 2 {
+			return f, errIL2PHeader
+		}
+		f.PID = il2pPIDs[pid]
+		if ui == 1 {
+			f.Control = UControl(UI, pf)
+		} else {
+			f.Control = IControl(control>>3&7, control&7, pf)
+			cmd = true
+		}
+	case pid == 0:
+		f.Control = SControl(il2pSTypes[control&3], control>>3&7, pf)
+	default:
+		f.Control = UControl(il2pUTypes[control>>3&7], pf)
+	}
+	f.Dest.C, f.Source.C = cmd, !cmd
+	f.Info = payload
+	return f, nil
+}
+
+// DecodeIL2P decodes an IL2P frame as received, starting with the
+// sync word, returning the frame and how many bytes were corrected.
+func DecodeIL2P(b []byte) (Frame, int, error) {
+	if len(b) < 3 {
+		return Frame{}, 0, errShortMsg
+	}
+	sync := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	if bits.OnesCount32(sync^IL2PSync) > il2pSyncTolerance {
+		return Frame{}, 0, errIL2PSync
+	}
+	h, corrected, err := il2pBlock(b[3:], il2pHeaderSize, il2pHeaderCheck)
+	if err != nil {
+		return Frame{}, 0, err
+	}
+	b = b[3+il2pHeaderSize+il2pHeaderCheck:]
+
+	var payload []byte
+	sizes, check := il2pBlocks(il2pField(h, 7, 11, 10), il2pField(h, 7, 0, 1) == 1)
+	for _, n := range sizes {
+		data, c, err := il2pBlock(b, n, check)
+		if err != nil {
+			return Frame{}, 0, err
+		}
+		payload = append(payload, data...)
+		corrected += c
+		b = b[n+check:]
+	}
+
+	if il2pField(h, 7, 1, 1) == 0 {
+		f, err := DecodeFrame(payload)
+		return f, corrected, err
+	}
+	f, err := il2pFrame(h, payload)
+	return f, corrected, err
+}
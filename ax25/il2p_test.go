@@ -0,0 +1,246 @@
+package ax25
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dustin/go-aprs"
+)
+
+func TestIL2PScramble(t *testing.T) {
+	// The scrambler's output for zeros is its register sequence.
+	want := []byte{0x0f, 0x70, 0xb3, 0x6f, 0x43, 0x98, 0x48, 0xae}
+	if got := il2pLFSR(make([]byte, 8), true); !bytes.Equal(got, want) {
+		t.Errorf("Expected scrambled zeros % x, got % x", want, got)
+	}
+	in := []byte("The quick brown fox")
+	if got := il2pLFSR(il2pLFSR(in, true), false); !bytes.Equal(got, in) {
+		t.Errorf("Descrambling gave %q", got)
+	}
+}
+
+func TestIL2PBlocks(t *testing.T) {
+	tests := []struct {
+		n      int
+		maxFEC bool
+		sizes  []int
+		check  int
+	}{
+		{0, false, nil, 0},
+		{2, true, []int{2}, 16},
+		{61, false, []int{61}, 2},
+		{100, false, []int{100}, 4},
+		{300, false, []int{150, 150}, 6},
+		{247, false, []int{247}, 8},
+		{248, false, []int{124, 124}, 6},
+		{1023, false, []int{205, 205, 205, 204, 204}, 8},
+		{1023, true, []int{205, 205, 205, 204, 204}, 16},
+		{240, true, []int{120, 120}, 16},
+	}
+	for _, test := range tests {
+		sizes, check := il2pBlocks(test.n, test.maxFEC)
+		if !reflect.DeepEqual(sizes, test.sizes) || check != test.check {
+			t.Errorf("%v bytes maxFEC=%v: got %v with %v check bytes, want %v with %v",
+				test.n, test.maxFEC, sizes, check, test.sizes, test.check)
+		}
+	}
+}
+
+func TestIL2PHeader(t *testing.T) {
+	f := Frame{
+		Dest:    Address{Call: "KG6HWF", SSID: 1, C: true},
+		Source:  Address{Call: "N6ACK", SSID: 2},
+		Control: IControl(5, 2, true),
+		PID:     PIDNoL3,
+		Info:    []byte("hi"),
+	}
+	// SIXBIT callsigns in the low bits; UI, PID (0xf) and control
+	// (P, N(R) 5, N(S) 2) in bit 6; max FEC, header type 1 and a
+	// payload of 2 in bit 7; SSIDs in the last byte.
+	want := []byte{0xab, 0xe7, 0x56, 0x68, 0x77, 0x66, 0x6e,
+		0x16, 0x61, 0x23, 0xeb, 0x00, 0x12}
+	header, payload := il2pHeader(f, true)
+	if !bytes.Equal(header, want) {
+		t.Errorf("Expected header\n% x\ngot\n% x", want, header)
+	}
+	if !bytes.Equal(payload, f.Info) {
+		t.Errorf("Expected payload %q, got %q", f.Info, payload)
+	}
+
+	// Frames with digipeaters go whole behind a type 0 header.
+	f.Path = []Address{{Call: "WIDE1", SSID: 1}}
+	header, payload = il2pHeader(f, false)
+	want = make([]byte, il2pHeaderSize)
+	il2pSetField(want, 7, 11, 10, len(f.Encode()))
+	if !bytes.Equal(header, want) || !bytes.Equal(payload, f.Encode()) {
+		t.Errorf("Expected a type 0 header, got % x with % x", header, payload)
+	}
+}
+
+// il2pExamples are the example frames from the IL2P specification,
+// as used in Direwolf's IL2P tests: AX.25 frames and their IL2P
+// encodings after the sync word.
+var il2pExamples = []struct {
+	name       string
+	ax25, il2p []byte
+}{
+	{"S frame: KK4HEJ-7>KA2DEW-2 RR C P R5",
+		[]byte{0x96, 0x82, 0x64, 0x88, 0x8a, 0xae, 0xe4, 0x96, 0x96, 0x68, 0x90, 0x8a, 0x94, 0x6f,
+			0xb1},
+		[]byte{0x26, 0x57, 0x4d, 0x57, 0xf1, 0x96, 0xcc, 0x85, 0x42, 0xe7, 0x24, 0xf7, 0x2e,
+			0x8a, 0x97}},
+	{"UI frame: KK4HEJ-15>CQ R pid=F0",
+		[]byte{0x86, 0xa2, 0x40, 0x40, 0x40, 0x40, 0x60, 0x96, 0x96, 0x68, 0x90, 0x8a, 0x94, 0x7f,
+			0x03, 0xf0},
+		[]byte{0x6a, 0xea, 0x9c, 0xc2, 0x01, 0x11, 0xfc, 0x14, 0x1f, 0xda, 0x6e, 0xf2, 0x53,
+			0x91, 0xbd}},
+	{"I frame: KK4HEJ-2>KA2DEW-2 C P S4 R5 pid=CF with a payload",
+		[]byte{0x96, 0x82, 0x64, 0x88, 0x8a, 0xae, 0xe4, 0x96, 0x96, 0x68, 0x90, 0x8a, 0x94, 0x65,
+			0xb8, 0xcf, '0', '1', '2', '3', '4', '5', '6', '7', '8'},
+		[]byte{0x26, 0x13, 0x6d, 0x02, 0x8c, 0xfe, 0xfb, 0xe8, 0xaa, 0x94, 0x2d, 0x6a, 0x34,
+			0x43, 0x35,
+			0x3c, 0x69, 0x9f, 0x0c, 0x75, 0x5a, 0x38, 0xa1, 0x7f, 0xf3, 0xfc}},
+}
+
+func TestIL2PExamples(t *testing.T) {
+	sync := []byte{0xf1, 0x5e, 0x48}
+	for _, ex := range il2pExamples {
+		f, err := DecodeFrame(ex.ax25)
+		if err != nil {
+			t.Fatalf("%v: error decoding AX.25: %v", ex.name, err)
+		}
+		want := append(append([]byte{}, sync...), ex.il2p...)
+		got, err := EncodeIL2P(f, false)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%v: encoded\n% x\nexpected\n% x (%v)", ex.name, got, want, err)
+		}
+
+		g, corrected, err := DecodeIL2P(want)
+		if err != nil || corrected != 0 {
+			t.Fatalf("%v: error decoding IL2P: %v corrected, %v", ex.name, corrected, err)
+		}
+		if !f.IsCommand() && !f.IsResponse() {
+			// AX.25 v1 addresses come back as a response.
+			f.Dest.C, f.Source.C = false, true
+		}
+		if !bytes.Equal(g.Encode(), f.Encode()) {
+			t.Errorf("%v: decoded\n% x\nexpected\n% x", ex.name, g.Encode(), f.Encode())
+		}
+	}
+}
+
+func TestIL2PRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Dest: Address{Call: "KG6HWF", SSID: 1, C: true}, Source: Address{Call: "N6ACK"},
+			Control: IControl(1, 2, false), PID: PIDNetROM, Info: []byte("netrom")},
+		{Dest: Address{Call: "KG6HWF"}, Source: Address{Call: "N6ACK", SSID: 15, C: true},
+			Control: SControl(SREJ, 4, true)},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UControl(SABM, true)},
+		{Dest: Address{Call: "KG6HWF"}, Source: Address{Call: "N6ACK", C: true},
+			Control: UControl(DM, true)},
+		{Dest: Address{Call: "APRS", C: true}, Source: Address{Call: "KG6HWF", SSID: 9},
+			Control: UI, PID: PIDNoL3, Info: bytes.Repeat([]byte("long "), 200)},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UI, PID: 0xc3, Info: []byte("texnet")},
+		// Not translatable: a path, an odd PID, lower case and
+		// SABME.
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Path:    []Address{{Call: "WR6ABD", C: true}, {Call: "WIDE2", SSID: 1}},
+			Control: UI, PID: PIDNoL3, Info: []byte("path")},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UI, PID: 0x99, Info: []byte("odd")},
+		{Dest: Address{Call: "kg6hwf", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UI, PID: PIDNoL3},
+		{Dest: Address{Call: "KG6HWF", C: true}, Source: Address{Call: "N6ACK"},
+			Control: UControl(SABME, true)},
+	}
+	for _, f := range frames {
+		for _, maxFEC := range []bool{false, true} {
+			b, err := EncodeIL2P(f, maxFEC)
+			if err != nil {
+				t.Fatalf("Error encoding %v: %v", f, err)
+			}
+			got, corrected, err := DecodeIL2P(b)
+			if err != nil || corrected != 0 {
+				t.Fatalf("Error decoding %v: %v corrected, %v", f, corrected, err)
+			}
+			if !bytes.Equal(got.Encode(), f.Encode()) {
+				t.Errorf("maxFEC=%v: expected %v, got %v", maxFEC, f, got)
+			}
+		}
+	}
+}
+
+func TestIL2PAPRS(t *testing.T) {
+	m := aprs.ParseFrame(christmasMsg)
+	b, err := EncodeIL2PAPRS(m, false)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	f, _, err := DecodeIL2P(b)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	got, err := f.APRS(true)
+	if err != nil {
+		t.Fatalf("Error converting to APRS: %v", err)
+	}
+	want, _ := FrameFromAPRS(m, true).APRS(true)
+	if got.String() != want.String() {
+		t.Errorf("Expected %q, got %q", want.String(), got.String())
+	}
+}
+
+func TestIL2PCorrects(t *testing.T) {
+	f := Frame{Dest: Address{Call: "APRS", C: true}, Source: Address{Call: "KG6HWF"},
+		Control: UI, PID: PIDNoL3, Info: bytes.Repeat([]byte("0123456789"), 30)}
+	for _, maxFEC := range []bool{false, true} {
+		b, err := EncodeIL2P(f, maxFEC)
+		if err != nil {
+			t.Fatalf("Error encoding: %v", err)
+		}
+		// One bad sync bit, one bad header byte and three bad bytes
+		// in each of the two payload blocks.
+		b[0] ^= 0x10
+		b[5] ^= 0xff
+		for _, i := range []int{20, 40, 60, 200, 220, 240} {
+			b[i] ^= 0x81
+		}
+		got, corrected, err := DecodeIL2P(b)
+		if err != nil || !bytes.Equal(got.Info, f.Info) {
+			t.Fatalf("maxFEC=%v: got %v, %v", maxFEC, got, err)
+		}
+		if corrected != 7 {
+			t.Errorf("maxFEC=%v: expected 7 bytes corrected, got %v", maxFEC, corrected)
+		}
+
+		b[6] ^= 0xff
+		if _, _, err := DecodeIL2P(b); err != ErrIL2PUncorrectable {
+			t.Errorf("Expected ErrIL2PUncorrectable with two bad header bytes, got %v", err)
+		}
+	}
+}
+
+func TestIL2PErrors(t *testing.T) {
+	f := Frame{Dest: Address{Call: "APRS", C: true}, Source: Address{Call: "KG6HWF"},
+		Control: UI, PID: PIDNoL3, Info: make([]byte, 1024)}
+	if _, err := EncodeIL2P(f, false); err != ErrIL2PTooLong {
+		t.Errorf("Expected ErrIL2PTooLong, got %v", err)
+	}
+
+	f.Info = []byte("hi")
+	b, err := EncodeIL2P(f, false)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	if _, _, err := DecodeIL2P(b[:len(b)-1]); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected a truncated frame to be malformed, got %v", err)
+	}
+	b[0] ^= 0x30
+	if _, _, err := DecodeIL2P(b); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected a bad sync word to be malformed, got %v", err)
+	}
+}
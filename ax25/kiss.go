@@ -0,0 +1,228 @@
+package ax25
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+// KISS framing bytes.
+const (
+	FEND  = 0xc0 // Frame end
+	FESC  = 0xdb // Frame escape
+	TFEND = 0xdc // Transposed frame end
+	TFESC = 0xdd // Transposed frame escape
+)
+
+// A KISSCommand is the command in the low nibble of a KISS frame's
+// type byte.
+type KISSCommand byte
+
+// KISS commands.
+const (
+	KISSData        = KISSCommand(0x00)
+	KISSTXDelay     = KISSCommand(0x01)
+	KISSPersistence = KISSCommand(0x02)
+	KISSSlotTime    = KISSCommand(0x03)
+	KISSTXTail      = KISSCommand(0x04)
+	KISSFullDuplex  = KISSCommand(0x05)
+	KISSSetHardware = KISSCommand(0x06)
+	// KISSReturn takes the TNC out of KISS mode.  It applies to
+	// all ports.
+	KISSReturn = KISSCommand(0xff)
+)
+
+var errBadPort = errors.New("KISS port must be 0-15")
+
+// A KISSFrame is a frame exchanged with a KISS TNC.
+type KISSFrame struct {
+	// Port is the TNC port (0-15).
+	Port int
+	// Command is what the frame does.
+	Command KISSCommand
+	// Data is the unescaped frame content, e.g. an AX.25 frame for
+	// KISSData or the parameter for the other commands.
+	Data []byte
+}
+
+func kissEscape(b *bytes.Buffer, data []byte) {
+	for _, c := range data {
+		switch c {
+		case FEND:
+			b.Write([]byte{FESC, TFEND})
+		case FESC:
+			b.Write([]byte{FESC, TFESC})
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func kissUnescape(data []byte) []byte {
+	rv := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c == FESC && i+1 < len(data) {
+			i++
+			switch data[i] {
+			case TFEND:
+				c = FEND
+			case TFESC:
+				c = FESC
+			default:
+				// Invalid escape, keep the byte as is.
+				c = data[i]
+			}
+		}
+		rv = append(rv, c)
+	}
+	return rv
+}
+
+// Encode returns the frame with escaping and framing for sending to
+// a TNC.
+func (f KISSFrame) Encode() ([]byte, error) {
+	if f.Port < 0 || f.Port > 15 {
+		return nil, errBadPort
+	}
+	b := &bytes.Buffer{}
+	b.WriteByte(FEND)
+	if f.Command == KISSReturn {
+		b.WriteByte(byte(KISSReturn))
+	} else {
+		b.WriteByte(byte(f.Port)<<4 | byte(f.Command)&0xf)
+	}
+	kissEscape(b, f.Data)
+	b.WriteByte(FEND)
+	return b.Bytes(), nil
+}
+
+// KISSDecoder reads frames from a KISS TNC.
+type KISSDecoder struct {
+	r *bufio.Reader
+}
+
+// NewKISSDecoder gets a new KISS decoder over this reader.
+func NewKISSDecoder(r io.Reader) *KISSDecoder {
+	return &KISSDecoder{r: bufio.NewReader(r)}
+}
+
+// Next gets the next frame, skipping empty frames between FENDs.
+func (d *KISSDecoder) Next() (KISSFrame, error) {
+	for {
+		raw, err := d.r.ReadBytes(FEND)
+		if err != nil {
+			return KISSFrame{}, err
+		}
+		raw = raw[:len(raw)-1]
+		if len(raw) == 0 {
+			continue
+		}
+		if raw[0] == byte(KISSReturn) {
+			return KISSFrame{Command: KISSReturn, Data: kissUnescape(raw[1:])}, nil
+		}
+		return KISSFrame{
+			Port:    int(raw[0] >> 4),
+			Command: KISSCommand(raw[0] & 0xf),
+			Data:    kissUnescape(raw[1:]),
+		}, nil
+	}
+}
+
+// KISSEncoder writes frames to a KISS TNC.  It's safe for
+// concurrent use.
+type KISSEncoder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewKISSEncoder gets a new KISS encoder over this writer.
+func NewKISSEncoder(w io.Writer) *KISSEncoder {
+	return &KISSEncoder{w: w}
+}
+
+// WriteFrame sends a frame to the TNC.
+func (e *KISSEncoder) WriteFrame(f KISSFrame) error {
+	b, err := f.Encode()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Send sends an AX.25 frame for transmission on the given port.
+func (e *KISSEncoder) Send(port int, frame []byte) error {
+	return e.WriteFrame(KISSFrame{Port: port, Command: KISSData, Data: frame})
+}
+
+// SendAPRS sends an APRS frame for transmission on the given port.
+func (e *KISSEncoder) SendAPRS(port int, m aprs.Frame) error {
+	return e.Send(port, EncodeAPRSCommand(m))
+}
+
+// kissTime converts a duration to the 10ms units KISS uses.
+func kissTime(d time.Duration) byte {
+	n := d / (10 * time.Millisecond)
+	if n > 255 {
+		n = 255
+	}
+	if n < 0 {
+		n = 0
+	}
+	return byte(n)
+}
+
+func (e *KISSEncoder) command(port int, c KISSCommand, data ...byte) error {
+	return e.WriteFrame(KISSFrame{Port: port, Command: c, Data: data})
+}
+
+// SetTXDelay sets how long the transmitter is keyed before data is
+// sent.
+func (e *KISSEncoder) SetTXDelay(port int, d time.Duration) error {
+	return e.command(port, KISSTXDelay, kissTime(d))
+}
+
+// SetPersistence sets the persistence parameter p, where the chance
+// of transmitting in a slot is (p+1)/256.
+func (e *KISSEncoder) SetPersistence(port int, p byte) error {
+	return e.command(port, KISSPersistence, p)
+}
+
+// SetSlotTime sets the interval between channel access attempts.
+func (e *KISSEncoder) SetSlotTime(port int, d time.Duration) error {
+	return e.command(port, KISSSlotTime, kissTime(d))
+}
+
+// SetTXTail sets how long the transmitter stays keyed after data is
+// sent.
+func (e *KISSEncoder) SetTXTail(port int, d time.Duration) error {
+	return e.command(port, KISSTXTail, kissTime(d))
+}
+
+// SetFullDuplex sets whether the port transmits without waiting for
+// a clear channel.
+func (e *KISSEncoder) SetFullDuplex(port int, on bool) error {
+	var b byte
+	if on {
+		b = 1
+	}
+	return e.command(port, KISSFullDuplex, b)
+}
+
+// SetHardware sends a TNC specific hardware command.
+func (e *KISSEncoder) SetHardware(port int, data []byte) error {
+	return e.command(port, KISSSetHardware, data...)
+}
+
+// ExitKISS takes the TNC out of KISS mode.
+func (e *KISSEncoder) ExitKISS() error {
+	return e.command(0, KISSReturn)
+}
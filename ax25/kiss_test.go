@@ -0,0 +1,115 @@
+package ax25
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+func TestKISSEncode(t *testing.T) {
+	tests := []struct {
+		in  KISSFrame
+		exp []byte
+	}{
+		{KISSFrame{Data: []byte("hi")}, []byte{FEND, 0, 'h', 'i', FEND}},
+		{KISSFrame{Port: 2, Data: []byte{FEND, 1, FESC}},
+			[]byte{FEND, 0x20, FESC, TFEND, 1, FESC, TFESC, FEND}},
+		{KISSFrame{Port: 15, Command: KISSTXDelay, Data: []byte{50}},
+			[]byte{FEND, 0xf1, 50, FEND}},
+		{KISSFrame{Port: 3, Command: KISSReturn}, []byte{FEND, 0xff, FEND}},
+	}
+	for _, test := range tests {
+		got, err := test.in.Encode()
+		if err != nil {
+			t.Errorf("Error encoding %+v: %v", test.in, err)
+			continue
+		}
+		if !bytes.Equal(got, test.exp) {
+			t.Errorf("Encode(%+v) = % x, want % x", test.in, got, test.exp)
+		}
+	}
+
+	if _, err := (KISSFrame{Port: 16}).Encode(); err == nil {
+		t.Errorf("Expected error encoding port 16")
+	}
+}
+
+func TestKISSRoundTrip(t *testing.T) {
+	frames := []KISSFrame{
+		{Data: []byte{}},
+		{Port: 1, Data: []byte{FEND, FESC, TFEND, TFESC, FEND, FEND}},
+		{Port: 7, Command: KISSSetHardware, Data: []byte("TNC stuff")},
+		{Command: KISSReturn, Data: []byte{}},
+	}
+	b := &bytes.Buffer{}
+	// Leading garbage and repeated FENDs are ignored.
+	b.Write([]byte{FEND, FEND})
+	e := NewKISSEncoder(b)
+	for _, f := range frames {
+		if err := e.WriteFrame(f); err != nil {
+			t.Fatalf("Error writing %+v: %v", f, err)
+		}
+	}
+
+	d := NewKISSDecoder(b)
+	for _, exp := range frames {
+		got, err := d.Next()
+		if err != nil {
+			t.Fatalf("Error reading frame: %v", err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("Expected %+v, got %+v", exp, got)
+		}
+	}
+}
+
+func TestKISSCommands(t *testing.T) {
+	b := &bytes.Buffer{}
+	e := NewKISSEncoder(b)
+	e.SetTXDelay(1, 300*time.Millisecond)
+	e.SetPersistence(1, 63)
+	e.SetSlotTime(1, 100*time.Millisecond)
+	e.SetTXTail(1, 5*time.Second)
+	e.SetFullDuplex(1, true)
+	e.SetHardware(1, []byte{FEND})
+	e.ExitKISS()
+
+	exp := []byte{
+		FEND, 0x11, 30, FEND,
+		FEND, 0x12, 63, FEND,
+		FEND, 0x13, 10, FEND,
+		FEND, 0x14, 255, FEND,
+		FEND, 0x15, 1, FEND,
+		FEND, 0x16, FESC, TFEND, FEND,
+		FEND, 0xff, FEND,
+	}
+	if !bytes.Equal(b.Bytes(), exp) {
+		t.Errorf("Expected % x, got % x", exp, b.Bytes())
+	}
+}
+
+func TestDecoderEscapes(t *testing.T) {
+	// Bodies containing FEND and FESC used to be corrupted.
+	msg := aprs.ParseFrame("KG6HWF>APRS,WIDE2-1:>\xc0\xdb\xdc\xdd")
+	b := &bytes.Buffer{}
+	e := NewKISSEncoder(b)
+	e.SetTXDelay(0, 300*time.Millisecond)
+	if err := e.SendAPRS(3, msg); err != nil {
+		t.Fatalf("Error sending: %v", err)
+	}
+
+	d := NewDecoder(b)
+	got, err := d.Next()
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if string(got.Body) != string(msg.Body) {
+		t.Errorf("Expected body %q, got %q", msg.Body, got.Body)
+	}
+	if d.Port() != 3 {
+		t.Errorf("Expected port 3, got %v", d.Port())
+	}
+}
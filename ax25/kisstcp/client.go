@@ -0,0 +1,218 @@
+// Package kisstcp carries KISS TNC frames over TCP, as soft TNCs such
+// as Direwolf and soundmodem offer (usually on port 8001).
+package kisstcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs/ax25"
+)
+
+var (
+	// ErrClosed is returned from a closed Client or Server.
+	ErrClosed = errors.New("closed")
+	// ErrNotConnected is returned when sending through a Client
+	// that isn't connected to its TNC.
+	ErrNotConnected = errors.New("not connected to TNC")
+)
+
+// Default reconnection backoff limits.
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+const dialTimeout = 30 * time.Second
+
+// StateHandler is told when a Client connects to or disconnects
+// from its TNC.  err is why a connection was lost or couldn't be
+// made.
+type StateHandler interface {
+	StateChanged(connected bool, err error)
+}
+
+type dumbStateHandlerT struct{}
+
+func (d dumbStateHandlerT) StateChanged(bool, error) {
+}
+
+var dumbStateHandler dumbStateHandlerT
+
+// A Client is a connection to a KISS TCP TNC that reconnects with
+// exponential backoff whenever the connection is lost.
+type Client struct {
+	addr                   string
+	minBackoff, maxBackoff time.Duration
+	stateHandler           StateHandler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	conn     net.Conn
+	dec      *ax25.KISSDecoder
+	enc      *ax25.KISSEncoder
+	attempts int
+}
+
+// NewClient creates a client for the TNC at the given address
+// (host:port).  No connection is made until Next is called.
+func NewClient(addr string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		addr:         addr,
+		minBackoff:   DefaultMinBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+		stateHandler: dumbStateHandler,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// SetBackoff sets the shortest and longest delays between
+// reconnection attempts.
+func (c *Client) SetBackoff(min, max time.Duration) {
+	c.minBackoff, c.maxBackoff = min, max
+}
+
+// SetStateHandler sets a handler for connection state changes.
+func (c *Client) SetStateHandler(to StateHandler) {
+	c.stateHandler = to
+}
+
+// backoff returns how long to wait before the given reconnection
+// attempt (0 being the first).
+func (c *Client) backoff(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+	d := c.minBackoff
+	for i := 1; i < attempt && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d
+}
+
+// connect returns the current connection's decoder, establishing a
+// connection if needed.
+func (c *Client) connect() (net.Conn, *ax25.KISSDecoder, error) {
+	for {
+		c.mu.Lock()
+		if c.conn != nil {
+			defer c.mu.Unlock()
+			return c.conn, c.dec, nil
+		}
+		wait := c.backoff(c.attempts)
+		c.attempts++
+		c.mu.Unlock()
+
+		select {
+		case <-c.ctx.Done():
+			return nil, nil, ErrClosed
+		case <-time.After(wait):
+		}
+
+		d := net.Dialer{Timeout: dialTimeout}
+		conn, err := d.DialContext(c.ctx, "tcp", c.addr)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return nil, nil, ErrClosed
+			}
+			c.stateHandler.StateChanged(false, err)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.ctx.Err() != nil {
+			c.mu.Unlock()
+			conn.Close()
+			return nil, nil, ErrClosed
+		}
+		c.conn = conn
+		c.dec = ax25.NewKISSDecoder(conn)
+		c.enc = ax25.NewKISSEncoder(conn)
+		c.mu.Unlock()
+		c.stateHandler.StateChanged(true, nil)
+	}
+}
+
+// disconnect drops the given connection if it's still current.
+func (c *Client) disconnect(conn net.Conn, err error) {
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn, c.dec, c.enc = nil, nil, nil
+	c.mu.Unlock()
+	conn.Close()
+	if c.ctx.Err() == nil {
+		c.stateHandler.StateChanged(false, err)
+	}
+}
+
+// Next returns the next frame from the TNC, connecting or
+// reconnecting as necessary.  It returns ErrClosed once the client
+// is closed.
+func (c *Client) Next() (ax25.KISSFrame, error) {
+	for {
+		conn, dec, err := c.connect()
+		if err != nil {
+			return ax25.KISSFrame{}, err
+		}
+		f, err := dec.Next()
+		if err == nil {
+			c.mu.Lock()
+			c.attempts = 0
+			c.mu.Unlock()
+			return f, nil
+		}
+		c.disconnect(conn, err)
+	}
+}
+
+// WriteFrame sends a frame to the TNC.  Frames sent while there's no
+// connection fail with ErrNotConnected rather than waiting for one.
+func (c *Client) WriteFrame(f ax25.KISSFrame) error {
+	c.mu.Lock()
+	conn, enc := c.conn, c.enc
+	c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if enc == nil {
+		return ErrNotConnected
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		c.disconnect(conn, err)
+		return err
+	}
+	return nil
+}
+
+// Send sends an AX.25 frame for transmission on the given port.
+func (c *Client) Send(port int, frame []byte) error {
+	return c.WriteFrame(ax25.KISSFrame{Port: port, Command: ax25.KISSData, Data: frame})
+}
+
+// Close disconnects the client and stops it from reconnecting.
+func (c *Client) Close() error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	c.cancel()
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.disconnect(conn, ErrClosed)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package kisstcp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/ax25"
+)
+
+var testFrame = ax25.EncodeAPRSCommand(aprs.ParseFrame("KG6HWF>APRS,WIDE2-1:>\xc0 test"))
+
+type recordingStateHandler struct {
+	mu     sync.Mutex
+	states []bool
+}
+
+func (r *recordingStateHandler) StateChanged(connected bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, connected)
+}
+
+func (r *recordingStateHandler) get() []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool{}, r.states...)
+}
+
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	return l
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); {
+		if f() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %v", what)
+}
+
+func TestClientReconnects(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	c := NewClient(l.Addr().String())
+	defer c.Close()
+	c.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	states := &recordingStateHandler{}
+	c.SetStateHandler(states)
+
+	if err := c.Send(0, testFrame); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected before connecting, got %v", err)
+	}
+
+	got := make(chan ax25.KISSFrame)
+	go func() {
+		for {
+			f, err := c.Next()
+			if err != nil {
+				close(got)
+				return
+			}
+			got <- f
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Error accepting: %v", err)
+		}
+		if err := ax25.NewKISSEncoder(conn).Send(i, testFrame); err != nil {
+			t.Fatalf("Error sending: %v", err)
+		}
+		f := <-got
+		if f.Port != i || f.Command != ax25.KISSData || !bytes.Equal(f.Data, testFrame) {
+			t.Errorf("Connection %v: got %+v", i, f)
+		}
+
+		if err := c.Send(3, testFrame); err != nil {
+			t.Fatalf("Error sending to TNC: %v", err)
+		}
+		f, err = ax25.NewKISSDecoder(conn).Next()
+		if err != nil || f.Port != 3 || !bytes.Equal(f.Data, testFrame) {
+			t.Errorf("TNC got %+v, %v", f, err)
+		}
+		conn.Close()
+	}
+
+	waitFor(t, "the second disconnect", func() bool { return len(states.get()) >= 4 })
+	if got := states.get(); got[0] != true || got[1] != false || got[2] != true || got[3] != false {
+		t.Errorf("Expected connect, disconnect, connect, disconnect, got %v", got)
+	}
+
+	c.Close()
+	if _, ok := <-got; ok {
+		t.Errorf("Expected Next to fail after Close")
+	}
+	if err := c.Send(0, testFrame); err != ErrClosed {
+		t.Errorf("Expected ErrClosed sending after Close, got %v", err)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed closing twice, got %v", err)
+	}
+}
+
+func TestClientCloseWhileDialing(t *testing.T) {
+	// Find an address with nothing listening.
+	l := listen(t)
+	addr := l.Addr().String()
+	l.Close()
+
+	c := NewClient(addr)
+	c.SetBackoff(time.Hour, time.Hour)
+	errc := make(chan error)
+	go func() {
+		_, err := c.Next()
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-errc:
+		if err != ErrClosed {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Next didn't return after Close")
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	l := listen(t)
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		c := NewClient(l.Addr().String())
+		defer c.Close()
+		clients = append(clients, c)
+	}
+	got := make(chan ax25.KISSFrame, 10)
+	for _, c := range clients {
+		go func(c *Client) {
+			for {
+				f, err := c.Next()
+				if err != nil {
+					return
+				}
+				got <- f
+			}
+		}(c)
+	}
+	waitFor(t, "clients", func() bool { return s.Clients() == 2 })
+
+	s.Broadcast(ax25.KISSFrame{Port: 1, Data: testFrame})
+	for i := 0; i < 2; i++ {
+		select {
+		case f := <-got:
+			if f.Port != 1 || !bytes.Equal(f.Data, testFrame) {
+				t.Errorf("Client got %+v", f)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for broadcast frames")
+		}
+	}
+
+	if err := clients[0].Send(2, testFrame); err != nil {
+		t.Fatalf("Error sending: %v", err)
+	}
+	select {
+	case f := <-s.Frames():
+		if f.Port != 2 || !bytes.Equal(f.Data, testFrame) {
+			t.Errorf("Server got %+v", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for a frame from a client")
+	}
+
+	clients[1].Close()
+	waitFor(t, "a client to leave", func() bool { return s.Clients() == 1 })
+
+	s.Close()
+	if err := <-served; err != ErrClosed {
+		t.Errorf("Expected Serve to return ErrClosed, got %v", err)
+	}
+	waitFor(t, "clients to be disconnected", func() bool { return s.Clients() == 0 })
+	if err := s.Close(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed closing twice, got %v", err)
+	}
+}
+
+func TestServerSlowClient(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	l := listen(t)
+	go s.Serve(l)
+
+	// A client that never reads shouldn't hold up Broadcast.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer conn.Close()
+	waitFor(t, "the client", func() bool { return s.Clients() == 1 })
+
+	big := ax25.KISSFrame{Data: bytes.Repeat([]byte{'x'}, 4096)}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10*clientQueue; i++ {
+			s.Broadcast(big)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Broadcast blocked on a slow client")
+	}
+}
+
+// failingListener fails every Accept, as when out of descriptors.
+type failingListener struct {
+	net.Listener
+	mu      sync.Mutex
+	accepts int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepts++
+	return nil, errors.New("too many open files")
+}
+
+func (l *failingListener) Close() error {
+	return nil
+}
+
+func TestServerAcceptBackoff(t *testing.T) {
+	s := NewServer()
+	l := &failingListener{}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+	if err := <-done; err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.accepts > 10 {
+		t.Errorf("Expected Serve to back off, got %v accepts", l.accepts)
+	}
+}
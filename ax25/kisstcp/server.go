@@ -0,0 +1,215 @@
+package kisstcp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs/ax25"
+)
+
+const (
+	// clientQueue is how many frames are queued for each client
+	// before the oldest are dropped.
+	clientQueue = 100
+	// writeTimeout is how long a client may block writes before
+	// it's disconnected.
+	writeTimeout = 30 * time.Second
+	// serverQueue is how many frames from clients are queued
+	// before their reads stall.
+	serverQueue = 32
+	// maxAcceptDelay is the longest to wait before accepting again
+	// after an error.
+	maxAcceptDelay = time.Second
+)
+
+// A Server shares a TNC with KISS TCP clients.  Frames given to
+// Broadcast (e.g. those heard on RF) are sent to every client, and
+// frames clients send are delivered on Frames.
+type Server struct {
+	frames chan ax25.KISSFrame
+	closed chan bool
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	clients   map[*serverClient]bool
+}
+
+type serverClient struct {
+	conn  net.Conn
+	queue chan ax25.KISSFrame
+}
+
+// NewServer gets a server with no listeners.
+func NewServer() *Server {
+	return &Server{
+		frames:  make(chan ax25.KISSFrame, serverQueue),
+		closed:  make(chan bool),
+		clients: map[*serverClient]bool{},
+	}
+}
+
+// ListenAndServe listens on the TCP address (e.g. ":8001") and
+// serves clients until the server is closed.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts clients from the listener until the server is
+// closed, when it returns ErrClosed, or the listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		l.Close()
+		return ErrClosed
+	default:
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return ErrClosed
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Probably out of file descriptors; back off
+			// as net/http does rather than spinning.
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			select {
+			case <-s.closed:
+				return ErrClosed
+			case <-time.After(delay):
+			}
+			continue
+		}
+		delay = 0
+		c := &serverClient{conn: conn, queue: make(chan ax25.KISSFrame, clientQueue)}
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			conn.Close()
+			return ErrClosed
+		default:
+		}
+		s.clients[c] = true
+		s.mu.Unlock()
+		go s.write(c)
+		go s.read(c)
+	}
+}
+
+// Frames returns the channel frames sent by clients are delivered
+// on, including any KISS commands.  Clients stall if it isn't
+// drained.
+func (s *Server) Frames() <-chan ax25.KISSFrame {
+	return s.frames
+}
+
+// Broadcast queues a frame for every client without blocking.  A
+// client that isn't keeping up loses its oldest queued frames.
+func (s *Server) Broadcast(f ax25.KISSFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.enqueue(f)
+	}
+}
+
+// Clients returns how many clients are connected.
+func (s *Server) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+func (c *serverClient) enqueue(f ax25.KISSFrame) {
+	for {
+		select {
+		case c.queue <- f:
+			return
+		default:
+		}
+		select {
+		case <-c.queue:
+		default:
+		}
+	}
+}
+
+// remove forgets a client and stops its writer.
+func (s *Server) remove(c *serverClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c] {
+		delete(s.clients, c)
+		close(c.queue)
+	}
+}
+
+func (s *Server) write(c *serverClient) {
+	e := ax25.NewKISSEncoder(c.conn)
+	for f := range c.queue {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := e.WriteFrame(f); err != nil {
+			// The reader notices the closed connection and
+			// removes the client.
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (s *Server) read(c *serverClient) {
+	defer s.remove(c)
+	defer c.conn.Close()
+	d := ax25.NewKISSDecoder(c.conn)
+	for {
+		f, err := d.Next()
+		if err != nil {
+			return
+		}
+		select {
+		case s.frames <- f:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Close stops all listeners and disconnects all clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+		return ErrClosed
+	default:
+	}
+	close(s.closed)
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	return nil
+}
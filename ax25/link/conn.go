@@ -0,0 +1,612 @@
+package link
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs/ax25"
+)
+
+type state int
+
+const (
+	stateDisconnected state = iota
+	// stateConnecting is waiting for a UA to our SABM or SABME.
+	stateConnecting
+	stateConnected
+	// stateRecovery is waiting for a final response to our poll
+	// after T1 expired.
+	stateRecovery
+	// stateResetting is waiting for a UA to the SABM or SABME we
+	// sent to reset the link after a sequence error.
+	stateResetting
+	// stateDisconnecting is waiting for a UA to our DISC.
+	stateDisconnecting
+)
+
+// maxQueued is how many frames' worth of data Write buffers before
+// blocking.
+const maxQueued = 32
+
+// maxReceived is how much unread data may be buffered before we
+// tell the remote station we're busy.
+const maxReceived = 64 * 1024
+
+// A Conn is a connected AX.25 link to a remote station.  It
+// satisfies net.Conn.
+type Conn struct {
+	p             *Port
+	local, remote ax25.Address
+	path          []ax25.Address
+	cond          *sync.Cond // on p.mu
+
+	state state
+	err   error
+
+	// modulo is 8 or 128, as set up by SABM or SABME.
+	modulo int
+	// vs is the next N(S) to send, va the oldest unacknowledged and
+	// high the next never sent; vs only lags high while
+	// retransmitting.
+	vs, va, high int
+	vr           int
+	sent         [][]byte
+	queue        [][]byte
+	recv         bytes.Buffer
+
+	peerBusy bool
+	ownBusy  bool
+	rejSent  bool
+	retries  int
+	t1, t3   *time.Timer
+
+	readDeadline, writeDeadline time.Time
+}
+
+func (p *Port) newConn(remote ax25.Address, path []ax25.Address) *Conn {
+	remote.C = false
+	c := &Conn{
+		p:      p,
+		local:  p.call,
+		remote: remote,
+		path:   path,
+		cond:   sync.NewCond(&p.mu),
+		modulo: 8,
+	}
+	c.t1 = time.AfterFunc(time.Hour, c.locked(c.t1Expired))
+	c.t1.Stop()
+	c.t3 = time.AfterFunc(time.Hour, c.locked(c.t3Expired))
+	c.t3.Stop()
+	return c
+}
+
+func (c *Conn) locked(f func()) func() {
+	return func() {
+		c.p.mu.Lock()
+		defer c.p.mu.Unlock()
+		f()
+	}
+}
+
+// moduloFor returns the modulo asked for by SABM or SABME.
+func moduloFor(t byte) int {
+	if t == ax25.SABME {
+		return 128
+	}
+	return 8
+}
+
+// seqDiff is how far b is ahead of a, modulo the link's modulo.
+func (c *Conn) seqDiff(a, b int) int {
+	return (b - a + c.modulo) % c.modulo
+}
+
+// next is the sequence number after n.
+func (c *Conn) next(n int) int {
+	return (n + 1) % c.modulo
+}
+
+// window is the most unacknowledged I frames we may have
+// outstanding.
+func (c *Conn) window() int {
+	if c.modulo == 128 {
+		return c.p.config.Window128
+	}
+	return c.p.config.Window
+}
+
+func (c *Conn) frame(control uint16, command bool) ax25.Frame {
+	f := ax25.Frame{
+		Dest:      c.remote,
+		Source:    c.local,
+		Path:      append([]ax25.Address{}, c.path...),
+		Control:   control,
+		Modulo128: c.modulo == 128,
+	}
+	f.Dest.C = command
+	f.Source.C = !command
+	return f
+}
+
+func (c *Conn) sendU(t byte, command, pf bool) {
+	c.p.send(c.frame(ax25.UControl(t, pf), command))
+}
+
+func (c *Conn) sendS(t byte, command, pf bool) {
+	if c.modulo == 128 {
+		c.p.send(c.frame(ax25.SControl128(t, c.vr, pf), command))
+	} else {
+		c.p.send(c.frame(ax25.SControl(t, c.vr, pf), command))
+	}
+}
+
+// sendRR sends an RR, or an RNR while we can't take more data.
+func (c *Conn) sendRR(command, pf bool) {
+	if c.ownBusy {
+		c.sendS(ax25.RNR, command, pf)
+	} else {
+		c.sendS(ax25.RR, command, pf)
+	}
+}
+
+// sendSABM asks for a link with the current modulo.
+func (c *Conn) sendSABM() {
+	if c.modulo == 128 {
+		c.sendU(ax25.SABME, true, true)
+	} else {
+		c.sendU(ax25.SABM, true, true)
+	}
+}
+
+func (c *Conn) sendI(ns int, data []byte) {
+	control := ax25.IControl(c.vr, ns, false)
+	if c.modulo == 128 {
+		control = ax25.IControl128(c.vr, ns, false)
+	}
+	f := c.frame(control, true)
+	f.PID = ax25.PIDNoL3
+	f.Info = data
+	c.p.send(f)
+}
+
+func (c *Conn) startT1() {
+	c.t3.Stop()
+	c.t1.Reset(c.p.config.T1)
+}
+
+func (c *Conn) startT3() {
+	c.t1.Stop()
+	c.t3.Reset(c.p.config.T3)
+}
+
+// resetLink clears the sequence state for a new or reset link using
+// the current modulo.
+func (c *Conn) resetLink() {
+	c.vs, c.va, c.high, c.vr = 0, 0, 0, 0
+	c.sent = make([][]byte, c.modulo)
+	c.peerBusy, c.ownBusy, c.rejSent = false, false, false
+	c.retries = 0
+}
+
+func (c *Conn) setConnected() {
+	c.state = stateConnected
+	c.retries = 0
+	c.startT3()
+	c.cond.Broadcast()
+}
+
+// connect sends a SABM or SABME for the given modulo and waits for
+// the answer.
+func (c *Conn) connect(modulo int) {
+	c.modulo = modulo
+	c.resetLink()
+	c.state = stateConnecting
+	c.sendSABM()
+	c.startT1()
+}
+
+// reestablish resets a link that's lost its place in the sequence.
+// Anything sent but not yet acknowledged is lost.
+func (c *Conn) reestablish() {
+	c.resetLink()
+	c.state = stateResetting
+	c.sendSABM()
+	c.startT1()
+	c.cond.Broadcast()
+}
+
+// disconnected drops the link.  Reads return err once buffered data
+// is consumed.
+func (c *Conn) disconnected(err error) {
+	if c.state == stateDisconnected {
+		return
+	}
+	c.state = stateDisconnected
+	c.err = err
+	c.t1.Stop()
+	c.t3.Stop()
+	if c.p.conns[c.remote.String()] == c {
+		delete(c.p.conns, c.remote.String())
+	}
+	c.cond.Broadcast()
+}
+
+func (c *Conn) handle(f ax25.Frame) {
+	switch f.Kind() {
+	case ax25.IFrame:
+		c.handleI(f)
+	case ax25.SFrame:
+		c.handleS(f)
+	default:
+		c.handleU(f)
+	}
+}
+
+func (c *Conn) handleU(f ax25.Frame) {
+	switch f.Type() {
+	case ax25.SABM, ax25.SABME:
+		switch c.state {
+		case stateConnecting, stateConnected, stateRecovery, stateResetting:
+			// A SABM crossing ours, or the remote resetting
+			// the link, perhaps with a different modulo.
+			c.modulo = moduloFor(f.Type())
+			c.resetLink()
+			c.sendU(ax25.UA, false, f.PF())
+			c.setConnected()
+			c.pump()
+		default:
+			c.sendU(ax25.DM, false, f.PF())
+		}
+	case ax25.DISC:
+		c.sendU(ax25.UA, false, f.PF())
+		c.disconnected(nil)
+	case ax25.UA:
+		switch c.state {
+		case stateConnecting, stateResetting:
+			c.setConnected()
+			c.pump()
+		case stateDisconnecting:
+			c.disconnected(nil)
+		}
+	case ax25.DM:
+		switch {
+		case c.state == stateConnecting && c.modulo == 128:
+			// A v2.0 station that doesn't know SABME.
+			c.connect(8)
+		case c.state == stateConnecting:
+			c.disconnected(ErrRefused)
+		case c.state == stateDisconnecting:
+			c.disconnected(nil)
+		default:
+			c.disconnected(ErrReset)
+		}
+	case ax25.FRMR:
+		if c.state == stateConnecting && c.modulo == 128 {
+			c.connect(8)
+			return
+		}
+		c.disconnected(ErrReset)
+	}
+}
+
+// ack handles an acknowledgement up to nr, reporting whether it was
+// valid.
+func (c *Conn) ack(nr int) bool {
+	if c.seqDiff(c.va, nr) > c.seqDiff(c.va, c.high) {
+		return false
+	}
+	if nr == c.va {
+		return true
+	}
+	for c.va != nr {
+		c.sent[c.va] = nil
+		c.va = c.next(c.va)
+	}
+	if c.seqDiff(c.va, c.vs) > c.seqDiff(c.va, c.high) {
+		c.vs = c.va
+	}
+	c.cond.Broadcast()
+	if c.state == stateConnected {
+		c.retries = 0
+		if c.va == c.high {
+			c.startT3()
+		} else {
+			c.startT1()
+		}
+	}
+	return true
+}
+
+func (c *Conn) handleI(f ax25.Frame) {
+	if c.state != stateConnected && c.state != stateRecovery {
+		return
+	}
+	if !c.ack(f.NR()) {
+		// It acknowledges something we never sent.
+		c.reestablish()
+		return
+	}
+	switch {
+	case c.ownBusy:
+		// Dropped; the remote resends once we're ready.
+		c.sendRR(false, f.PF())
+	case f.NS() == c.vr:
+		c.recv.Write(f.Info)
+		c.vr = c.next(c.vr)
+		c.rejSent = false
+		c.ownBusy = c.recv.Len() >= maxReceived
+		c.cond.Broadcast()
+		if f.PF() || c.ownBusy || !c.pump() {
+			c.sendRR(false, f.PF())
+		}
+	case !c.rejSent:
+		c.rejSent = true
+		c.sendS(ax25.REJ, false, f.PF())
+	case f.PF():
+		c.sendRR(false, true)
+	}
+}
+
+func (c *Conn) handleS(f ax25.Frame) {
+	if c.state != stateConnected && c.state != stateRecovery {
+		return
+	}
+	c.peerBusy = f.Type() == ax25.RNR
+	if f.IsCommand() && f.PF() {
+		c.sendRR(false, true)
+	}
+	va := c.va
+	switch {
+	case f.Type() == ax25.SREJ && !f.PF():
+		// Only an SREJ with its F bit set acknowledges the frames
+		// before the one it asks for; others may be asked for
+		// next.
+		if c.seqDiff(c.va, f.NR()) >= c.seqDiff(c.va, c.high) {
+			c.reestablish()
+			return
+		}
+	case !c.ack(f.NR()):
+		c.reestablish()
+		return
+	}
+
+	if c.state == stateRecovery {
+		if !f.IsResponse() || !f.PF() {
+			return
+		}
+		// The remote answered our poll; resend whatever it
+		// hasn't got.  Retries only start over once it
+		// acknowledges something.
+		c.state = stateConnected
+		if c.va != va {
+			c.retries = 0
+		}
+		c.vs = c.va
+		if c.va == c.high {
+			c.startT3()
+		} else {
+			c.startT1()
+		}
+		c.pump()
+		return
+	}
+
+	switch f.Type() {
+	case ax25.REJ:
+		c.vs = c.va
+	case ax25.SREJ:
+		if data := c.sent[f.NR()]; data != nil {
+			c.sendI(f.NR(), data)
+			c.startT1()
+		}
+	}
+	c.pump()
+}
+
+// pump sends queued and retransmitted I frames while the window
+// allows, reporting whether any were sent.
+func (c *Conn) pump() bool {
+	sent := false
+	for c.state == stateConnected && !c.peerBusy &&
+		c.seqDiff(c.va, c.vs) < c.window() {
+		var data []byte
+		switch {
+		case c.vs != c.high:
+			data = c.sent[c.vs]
+		case len(c.queue) > 0:
+			data = c.queue[0]
+			c.queue = c.queue[1:]
+			c.sent[c.vs] = data
+			c.high = c.next(c.high)
+			c.cond.Broadcast()
+		default:
+			return sent
+		}
+		c.sendI(c.vs, data)
+		c.vs = c.next(c.vs)
+		c.startT1()
+		sent = true
+	}
+	return sent
+}
+
+func (c *Conn) t1Expired() {
+	c.retries++
+	switch c.state {
+	case stateConnecting, stateResetting:
+		if c.retries > c.p.config.N2 {
+			c.disconnected(ErrTimeout)
+			return
+		}
+		c.sendSABM()
+	case stateDisconnecting:
+		if c.retries > c.p.config.N2 {
+			c.disconnected(nil)
+			return
+		}
+		c.sendU(ax25.DISC, true, true)
+	case stateConnected, stateRecovery:
+		if c.retries > c.p.config.N2 {
+			c.sendU(ax25.DM, false, false)
+			c.disconnected(ErrTimeout)
+			return
+		}
+		c.state = stateRecovery
+		c.sendRR(true, true)
+	default:
+		return
+	}
+	c.t1.Reset(c.p.config.T1)
+}
+
+func (c *Conn) t3Expired() {
+	if c.state != stateConnected {
+		return
+	}
+	c.state = stateRecovery
+	c.retries = 0
+	c.sendRR(true, true)
+	c.startT1()
+}
+
+// wait waits for the connection's state to change, or the deadline
+// to pass.  It must be called with p.mu held.
+func (c *Conn) wait(deadline time.Time) {
+	if !deadline.IsZero() {
+		t := time.AfterFunc(time.Until(deadline), c.locked(c.cond.Broadcast))
+		defer t.Stop()
+	}
+	c.cond.Wait()
+}
+
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (c *Conn) active() bool {
+	return c.state == stateConnected || c.state == stateRecovery ||
+		c.state == stateResetting
+}
+
+// Read reads data received on the link.  It returns io.EOF once the
+// remote station disconnects and everything it sent has been read.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	for c.recv.Len() == 0 {
+		if !c.active() && c.state != stateDisconnecting {
+			if c.err != nil {
+				return 0, c.err
+			}
+			return 0, io.EOF
+		}
+		if expired(c.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.wait(c.readDeadline)
+	}
+	n, err := c.recv.Read(b)
+	if c.ownBusy && c.recv.Len() < maxReceived/2 {
+		c.ownBusy = false
+		if c.active() {
+			c.sendRR(false, false)
+		}
+	}
+	return n, err
+}
+
+// Write queues data to send on the link, blocking while too much is
+// already waiting.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	n := 0
+	for n < len(b) {
+		for c.active() && len(c.queue) >= maxQueued {
+			if expired(c.writeDeadline) {
+				return n, os.ErrDeadlineExceeded
+			}
+			c.wait(c.writeDeadline)
+		}
+		if !c.active() {
+			if c.err != nil {
+				return n, c.err
+			}
+			return n, ErrClosed
+		}
+		chunk := len(b) - n
+		if chunk > c.p.config.PacLen {
+			chunk = c.p.config.PacLen
+		}
+		c.queue = append(c.queue, append([]byte{}, b[n:n+chunk]...))
+		n += chunk
+		c.pump()
+	}
+	return n, nil
+}
+
+// Close sends any queued data and disconnects the link.
+func (c *Conn) Close() error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	for c.active() && (len(c.queue) > 0 || c.va != c.high) {
+		c.wait(time.Time{})
+	}
+	switch c.state {
+	case stateConnected, stateRecovery, stateResetting:
+		c.state = stateDisconnecting
+		c.retries = 0
+		c.sendU(ax25.DISC, true, true)
+		c.startT1()
+	case stateConnecting:
+		c.disconnected(ErrClosed)
+	}
+	for c.state == stateDisconnecting {
+		c.wait(time.Time{})
+	}
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	return nil
+}
+
+// LocalAddr returns our own address.
+func (c *Conn) LocalAddr() net.Addr {
+	return Addr{c.local}
+}
+
+// RemoteAddr returns the remote station's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return Addr{c.remote}
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.cond.Broadcast()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	c.readDeadline = t
+	c.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	c.writeDeadline = t
+	c.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,375 @@
+// Package link implements connected-mode AX.25 (the data link layer
+// used by BBSes and nodes) over a KISS TNC.
+//
+// Links use modulo 8 (SABM, AX.25 v2.0) or modulo 128 (SABME, v2.2)
+// sequence numbers, whichever the connecting station asks for.
+// Dialing with Config.Modulo128 falls back to modulo 8 if the remote
+// station refuses SABME.
+//
+// XID parameter negotiation isn't supported, so selective reject is
+// receive-only: SREJ from the remote station is honored, but frames
+// received out of sequence are discarded and answered with REJ, which
+// every v2.0 station understands.
+package link
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs/ax25"
+)
+
+var (
+	// ErrRefused is returned when the remote station refuses a
+	// connection.
+	ErrRefused = errors.New("connection refused")
+	// ErrTimeout is returned when the remote station stops
+	// responding.
+	ErrTimeout = errors.New("link timed out")
+	// ErrReset is returned when the remote station resets or drops
+	// the link.
+	ErrReset = errors.New("link reset")
+	// ErrClosed is returned for operations on a closed Port or
+	// Conn.
+	ErrClosed = errors.New("link closed")
+	// ErrInUse is returned when dialing a station that's already
+	// connected.
+	ErrInUse = errors.New("already connected to that station")
+)
+
+// Config holds the link parameters.  Zero values are replaced with
+// defaults.
+type Config struct {
+	// T1 is how long to wait for an acknowledgement before
+	// retrying (default 3s).
+	T1 time.Duration
+	// T3 is how long an idle link goes before it's checked
+	// (default 5m).
+	T3 time.Duration
+	// N2 is how many times to retry before giving up (default 10).
+	N2 int
+	// Window is the most unacknowledged I frames outstanding on
+	// modulo 8 links (k, 1-7, default 4).
+	Window int
+	// Window128 is the most unacknowledged I frames outstanding on
+	// modulo 128 links (k, 1-127, default 32).
+	Window128 int
+	// Modulo128 makes Dial ask for a modulo 128 link.
+	Modulo128 bool
+	// PacLen is the most data in each I frame (default 256).
+	PacLen int
+	// Backlog is how many incoming connections may wait for
+	// Accept (default 4).
+	Backlog int
+}
+
+func (c Config) withDefaults() Config {
+	if c.T1 <= 0 {
+		c.T1 = 3 * time.Second
+	}
+	if c.T3 <= 0 {
+		c.T3 = 5 * time.Minute
+	}
+	if c.N2 <= 0 {
+		c.N2 = 10
+	}
+	if c.Window <= 0 || c.Window > 7 {
+		c.Window = 4
+	}
+	if c.Window128 <= 0 || c.Window128 > 127 {
+		c.Window128 = 32
+	}
+	if c.PacLen <= 0 {
+		c.PacLen = 256
+	}
+	if c.Backlog <= 0 {
+		c.Backlog = 4
+	}
+	return c
+}
+
+// An Addr is the address of a station, satisfying net.Addr.
+type Addr struct {
+	ax25.Address
+}
+
+// Network returns "ax25".
+func (a Addr) Network() string {
+	return "ax25"
+}
+
+// parseAddress parses a callsign with an optional SSID, such as
+// KG6HWF-2.
+func parseAddress(s string) (ax25.Address, error) {
+	call, ssid := strings.ToUpper(s), 0
+	if i := strings.IndexByte(call, '-'); i >= 0 {
+		n, err := strconv.Atoi(call[i+1:])
+		if err != nil || n < 0 || n > 15 {
+			return ax25.Address{}, fmt.Errorf("invalid SSID in %q", s)
+		}
+		call, ssid = call[:i], n
+	}
+	if call == "" || len(call) > 6 {
+		return ax25.Address{}, fmt.Errorf("invalid callsign %q", s)
+	}
+	return ax25.Address{Call: call, SSID: ssid}, nil
+}
+
+func sameStation(a, b ax25.Address) bool {
+	return a.Call == b.Call && a.SSID == b.SSID
+}
+
+// A Port runs AX.25 links for one callsign over a KISS TNC.
+type Port struct {
+	call     ax25.Address
+	kissPort int
+	config   Config
+	enc      *ax25.KISSEncoder
+	backlog  chan *Conn
+
+	mu        sync.Mutex
+	conns     map[string]*Conn
+	listening bool
+	closed    bool
+	err       error
+
+	omu    sync.Mutex
+	ocond  *sync.Cond
+	outq   []ax25.Frame
+	ostop  bool
+	closer sync.Once
+}
+
+// NewPort starts running links for the given callsign over the KISS
+// TNC read from r and written to w, using the given TNC port.
+func NewPort(call string, r io.Reader, w io.Writer, kissPort int, config Config) (*Port, error) {
+	a, err := parseAddress(call)
+	if err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+	p := &Port{
+		call:     a,
+		kissPort: kissPort,
+		config:   config,
+		enc:      ax25.NewKISSEncoder(w),
+		backlog:  make(chan *Conn, config.Backlog),
+		conns:    map[string]*Conn{},
+	}
+	p.ocond = sync.NewCond(&p.omu)
+	go p.readFrames(ax25.NewKISSDecoder(r))
+	go p.writeFrames()
+	return p, nil
+}
+
+// Addr returns the port's own address.
+func (p *Port) Addr() Addr {
+	return Addr{p.call}
+}
+
+// send queues a frame for transmission.  It never blocks, so it's
+// safe to call with p.mu held.
+func (p *Port) send(f ax25.Frame) {
+	p.omu.Lock()
+	defer p.omu.Unlock()
+	if !p.ostop {
+		p.outq = append(p.outq, f)
+		p.ocond.Signal()
+	}
+}
+
+func (p *Port) writeFrames() {
+	for {
+		p.omu.Lock()
+		for len(p.outq) == 0 && !p.ostop {
+			p.ocond.Wait()
+		}
+		if p.ostop {
+			p.omu.Unlock()
+			return
+		}
+		f := p.outq[0]
+		p.outq = p.outq[1:]
+		p.omu.Unlock()
+
+		if err := p.enc.Send(p.kissPort, f.Encode()); err != nil {
+			p.shutdown(err)
+			return
+		}
+	}
+}
+
+func (p *Port) readFrames(d *ax25.KISSDecoder) {
+	for {
+		kf, err := d.Next()
+		if err != nil {
+			p.shutdown(err)
+			return
+		}
+		if kf.Command == ax25.KISSData && kf.Port == p.kissPort {
+			p.receive(kf.Data)
+		}
+	}
+}
+
+// receive handles a frame heard on the port.  It's decoded as modulo
+// 8 until we know which link it's for.
+func (p *Port) receive(b []byte) {
+	f, err := ax25.DecodeFrame(b)
+	if err != nil || !sameStation(f.Dest, p.call) {
+		return
+	}
+	// Frames still on their way through digipeaters aren't ours
+	// yet.
+	for _, a := range f.Path {
+		if !a.H() {
+			return
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if c, ok := p.conns[f.Source.String()]; ok {
+		if c.modulo == 128 && f.Kind() != ax25.UFrame {
+			if f, err = ax25.DecodeFrame128(b); err != nil {
+				return
+			}
+		}
+		c.handle(f)
+		return
+	}
+
+	c := p.newConn(f.Source, reversePath(f.Path))
+	switch {
+	case f.Kind() == ax25.UFrame && (f.Type() == ax25.SABM || f.Type() == ax25.SABME) &&
+		p.listening && len(p.backlog) < cap(p.backlog):
+		c.modulo = moduloFor(f.Type())
+		c.resetLink()
+		c.sendU(ax25.UA, false, f.PF())
+		c.setConnected()
+		p.conns[f.Source.String()] = c
+		p.backlog <- c
+	case f.IsCommand() && f.PF() || f.Kind() == ax25.UFrame &&
+		(f.Type() == ax25.SABM || f.Type() == ax25.SABME || f.Type() == ax25.DISC):
+		// Nobody's listening, or this frame is for a link
+		// that no longer exists.
+		c.sendU(ax25.DM, false, f.PF())
+	}
+}
+
+func reversePath(path []ax25.Address) []ax25.Address {
+	rv := make([]ax25.Address, len(path))
+	for i, a := range path {
+		a.C = false
+		rv[len(path)-1-i] = a
+	}
+	return rv
+}
+
+// Listen starts accepting incoming connections.  Until it's called,
+// connection requests are refused.
+func (p *Port) Listen() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listening = true
+}
+
+// Accept waits for an incoming connection.
+func (p *Port) Accept() (*Conn, error) {
+	p.Listen()
+	c, ok := <-p.backlog
+	if !ok {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return nil, p.closedErr()
+	}
+	return c, nil
+}
+
+// closedErr is why the port closed: the TNC's error, or ErrClosed.
+func (p *Port) closedErr() error {
+	if p.err != nil {
+		return p.err
+	}
+	return ErrClosed
+}
+
+// Dial connects to a remote station, optionally via digipeaters.
+func (p *Port) Dial(remote string, via ...string) (*Conn, error) {
+	ra, err := parseAddress(remote)
+	if err != nil {
+		return nil, err
+	}
+	var path []ax25.Address
+	for _, v := range via {
+		a, err := parseAddress(v)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, a)
+	}
+	if len(path) > 8 {
+		return nil, errors.New("too many digipeaters")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, p.closedErr()
+	}
+	if _, ok := p.conns[ra.String()]; ok {
+		return nil, ErrInUse
+	}
+	c := p.newConn(ra, path)
+	p.conns[ra.String()] = c
+	if p.config.Modulo128 {
+		c.connect(128)
+	} else {
+		c.connect(8)
+	}
+	for c.state == stateConnecting {
+		c.cond.Wait()
+	}
+	if c.state != stateConnected {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// shutdown stops the port after the TNC fails.
+func (p *Port) shutdown(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+	p.Close()
+}
+
+// Close drops all links and stops accepting connections.  The TNC
+// reader and writer are left for the caller to close.
+func (p *Port) Close() error {
+	p.closer.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		for _, c := range p.conns {
+			c.disconnected(ErrClosed)
+		}
+		close(p.backlog)
+		p.mu.Unlock()
+
+		p.omu.Lock()
+		p.ostop = true
+		p.ocond.Broadcast()
+		p.omu.Unlock()
+	})
+	return nil
+}
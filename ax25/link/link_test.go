@@ -0,0 +1,441 @@
+package link
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs/ax25"
+)
+
+var fastConfig = Config{T1: 50 * time.Millisecond, N2: 20}
+
+// relay copies KISS frames from src to dst, dropping those drop says
+// to.
+func relay(src io.Reader, dst io.WriteCloser, drop func(ax25.Frame) bool) {
+	defer dst.Close()
+	d := ax25.NewKISSDecoder(src)
+	e := ax25.NewKISSEncoder(dst)
+	for {
+		kf, err := d.Next()
+		if err != nil {
+			return
+		}
+		if f, err := ax25.DecodeFrame(kf.Data); err == nil && drop != nil && drop(f) {
+			continue
+		}
+		if e.WriteFrame(kf) != nil {
+			return
+		}
+	}
+}
+
+// pair connects two ports over an in-memory KISS pipe.
+func pair(t *testing.T, config Config, drop func(ax25.Frame) bool) (*Port, *Port) {
+	aOutR, aOutW := io.Pipe()
+	aInR, aInW := io.Pipe()
+	bOutR, bOutW := io.Pipe()
+	bInR, bInW := io.Pipe()
+	go relay(aOutR, bInW, drop)
+	go relay(bOutR, aInW, drop)
+
+	a, err := NewPort("N0CALL-1", aInR, aOutW, 0, config)
+	if err != nil {
+		t.Fatalf("Error making port: %v", err)
+	}
+	b, err := NewPort("N0CALL-2", bInR, bOutW, 0, config)
+	if err != nil {
+		t.Fatalf("Error making port: %v", err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+		aOutW.Close()
+		bOutW.Close()
+	})
+	return a, b
+}
+
+// connect dials b from a, returning both ends.
+func connect(t *testing.T, a, b *Port) (*Conn, *Conn) {
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := b.Accept()
+		if err != nil {
+			t.Errorf("Error accepting: %v", err)
+		}
+		accepted <- c
+	}()
+	// Make sure b is listening before dialing.
+	b.Listen()
+	ac, err := a.Dial("N0CALL-2")
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	select {
+	case bc := <-accepted:
+		return ac, bc
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out accepting")
+	}
+	return nil, nil
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in   string
+		exp  string
+		fail bool
+	}{
+		{in: "kg6hwf", exp: "KG6HWF"},
+		{in: "KG6HWF-2", exp: "KG6HWF-2"},
+		{in: "KG6HWF-16", fail: true},
+		{in: "KG6HWF-x", fail: true},
+		{in: "TOOLONG", fail: true},
+		{in: "", fail: true},
+	}
+	for _, test := range tests {
+		a, err := parseAddress(test.in)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q, got %v", test.in, a)
+			}
+			continue
+		}
+		if err != nil || a.String() != test.exp {
+			t.Errorf("Expected %v for %q, got %v/%v", test.exp, test.in, a, err)
+		}
+	}
+}
+
+func TestConnectExchange(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	ac, bc := connect(t, a, b)
+
+	if ac.RemoteAddr().String() != "N0CALL-2" || bc.RemoteAddr().String() != "N0CALL-1" {
+		t.Fatalf("Wrong addresses: %v and %v", ac.RemoteAddr(), bc.RemoteAddr())
+	}
+	if ac.LocalAddr().Network() != "ax25" {
+		t.Errorf("Wrong network: %v", ac.LocalAddr().Network())
+	}
+
+	if _, err := ac.Write([]byte("hello")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	buf := make([]byte, 100)
+	n, err := bc.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Expected hello, got %q/%v", buf[:n], err)
+	}
+
+	if _, err := bc.Write([]byte("hi yourself")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	n, err = ac.Read(buf)
+	if err != nil || string(buf[:n]) != "hi yourself" {
+		t.Fatalf("Expected reply, got %q/%v", buf[:n], err)
+	}
+
+	if _, err := ac.Write([]byte("bye")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if err := ac.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	got, err := ioutil.ReadAll(bc)
+	if err != nil || string(got) != "bye" {
+		t.Fatalf("Expected bye then EOF, got %q/%v", got, err)
+	}
+	if _, err := ac.Write([]byte("more")); err == nil {
+		t.Errorf("Expected error writing to a closed conn")
+	}
+}
+
+func TestRefused(t *testing.T) {
+	a, _ := pair(t, fastConfig, nil)
+	if c, err := a.Dial("N0CALL-2"); err != ErrRefused {
+		t.Fatalf("Expected refusal, got %v/%v", c, err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	p, err := NewPort("N0CALL", r, ioutil.Discard, 0,
+		Config{T1: 10 * time.Millisecond, N2: 2})
+	if err != nil {
+		t.Fatalf("Error making port: %v", err)
+	}
+	defer p.Close()
+	if c, err := p.Dial("N0CALL-2", "WIDE1-1"); err != ErrTimeout {
+		t.Fatalf("Expected timeout, got %v/%v", c, err)
+	}
+}
+
+func TestInUse(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	connect(t, a, b)
+	if _, err := a.Dial("N0CALL-2"); err != ErrInUse {
+		t.Fatalf("Expected in use error, got %v", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	ac, _ := connect(t, a, b)
+	ac.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := ac.Read(make([]byte, 10))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+}
+
+func TestRemoteGone(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	ac, _ := connect(t, a, b)
+	// b forgets the link, so our poll gets a DM.
+	b.mu.Lock()
+	delete(b.conns, "N0CALL-1")
+	b.mu.Unlock()
+	ac.p.mu.Lock()
+	ac.t3Expired()
+	ac.p.mu.Unlock()
+	if _, err := ioutil.ReadAll(ac); err != ErrReset {
+		t.Fatalf("Expected reset, got %v", err)
+	}
+}
+
+func TestModulo128(t *testing.T) {
+	config := fastConfig
+	config.Modulo128 = true
+	a, b := pair(t, config, nil)
+	ac, bc := connect(t, a, b)
+	if ac.modulo != 128 || bc.modulo != 128 {
+		t.Fatalf("Expected modulo 128 links, got %v and %v", ac.modulo, bc.modulo)
+	}
+	transfer(t, ac, bc, 50000)
+	transfer(t, bc, ac, 20000)
+}
+
+func TestModulo128Fallback(t *testing.T) {
+	// A v2.0 station that answers SABME with DM.
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	defer outW.Close()
+	go func() {
+		d := ax25.NewKISSDecoder(outR)
+		e := ax25.NewKISSEncoder(inW)
+		for {
+			kf, err := d.Next()
+			if err != nil {
+				return
+			}
+			f, err := ax25.DecodeFrame(kf.Data)
+			if err != nil || f.Kind() != ax25.UFrame {
+				continue
+			}
+			reply := ax25.Frame{Dest: f.Source, Source: f.Dest}
+			reply.Source.C = true
+			switch f.Type() {
+			case ax25.SABME:
+				reply.Control = ax25.UControl(ax25.DM, true)
+			case ax25.SABM:
+				reply.Control = ax25.UControl(ax25.UA, true)
+			default:
+				continue
+			}
+			reply.Dest.C = false
+			e.Send(0, reply.Encode())
+		}
+	}()
+
+	p, err := NewPort("N0CALL-1", inR, outW, 0, Config{T1: 50 * time.Millisecond, Modulo128: true})
+	if err != nil {
+		t.Fatalf("Error making port: %v", err)
+	}
+	defer p.Close()
+	c, err := p.Dial("N0CALL-2")
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	if c.modulo != 8 {
+		t.Fatalf("Expected to fall back to modulo 8, got %v", c.modulo)
+	}
+}
+
+func TestNRError(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	ac, bc := connect(t, a, b)
+
+	// An I frame acknowledging something b never sent.
+	f := ac.frame(ax25.IControl(5, 0, false), true)
+	f.PID = ax25.PIDNoL3
+	f.Info = []byte("bogus")
+	b.mu.Lock()
+	bc.handle(f)
+	state, got := bc.state, bc.recv.Len()
+	b.mu.Unlock()
+	if state != stateResetting || got != 0 {
+		t.Fatalf("Expected the link to reset without delivering, got %v/%v", state, got)
+	}
+
+	// a accepts the reset and the link carries on.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		state = bc.state
+		b.mu.Unlock()
+		if state == stateConnected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Link never came back after reset")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	transfer(t, ac, bc, 2000)
+	transfer(t, bc, ac, 2000)
+}
+
+func transfer(t *testing.T, from, to *Conn, size int) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	go func() {
+		if _, err := from.Write(data); err != nil {
+			t.Errorf("Error writing: %v", err)
+		}
+	}()
+	got := make([]byte, size)
+	to.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.ReadFull(to, got); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Fatalf("Data corrupted in transfer")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	ac, bc := connect(t, a, b)
+	transfer(t, ac, bc, 50000)
+	transfer(t, bc, ac, 20000)
+}
+
+func TestLossyTransfer(t *testing.T) {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(1))
+	drop := func(f ax25.Frame) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Intn(5) == 0
+	}
+	for _, config := range []Config{
+		{T1: 50 * time.Millisecond, N2: 20, Window: 7, PacLen: 64},
+		{T1: 50 * time.Millisecond, N2: 20, Window128: 20, PacLen: 64, Modulo128: true},
+	} {
+		a, b := pair(t, config, drop)
+		ac, bc := connect(t, a, b)
+		transfer(t, ac, bc, 10000)
+		transfer(t, bc, ac, 5000)
+		if err := ac.Close(); err != nil {
+			t.Fatalf("Error closing: %v", err)
+		}
+	}
+}
+
+func TestReceiverBusy(t *testing.T) {
+	a, b := pair(t, fastConfig, nil)
+	ac, bc := connect(t, a, b)
+	// Fill b's buffer without reading it.
+	data := make([]byte, maxReceived*2)
+	done := make(chan error, 1)
+	go func() {
+		_, err := ac.Write(data)
+		done <- err
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		busy := bc.ownBusy
+		b.mu.Unlock()
+		if busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Receiver never got busy")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	got, err := io.ReadFull(bc, make([]byte, len(data)))
+	if err != nil || got != len(data) {
+		t.Fatalf("Error reading after busy: %v/%v", got, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+}
+
+func TestSREJ(t *testing.T) {
+	var mu sync.Mutex
+	holding := false
+	var sent []int
+	// Once holding, b never hears a's I frames, so nothing's
+	// acknowledged.
+	drop := func(f ax25.Frame) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if holding && f.Kind() == ax25.IFrame {
+			sent = append(sent, f.NS())
+			return true
+		}
+		return false
+	}
+	sentI := func(n int) []int {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			got := append([]int{}, sent...)
+			mu.Unlock()
+			if len(got) >= n || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	a, b := pair(t, Config{T1: 10 * time.Second, PacLen: 1}, drop)
+	ac, bc := connect(t, a, b)
+	mu.Lock()
+	holding = true
+	mu.Unlock()
+	if _, err := ac.Write([]byte("abcd")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if got := sentI(4); len(got) != 4 {
+		t.Fatalf("Expected 4 I frames sent, got %v", got)
+	}
+	mu.Lock()
+	sent = nil
+	mu.Unlock()
+
+	// b selectively rejects two of them, the later one first.
+	a.mu.Lock()
+	for _, nr := range []int{2, 1} {
+		ac.handle(bc.frame(ax25.SControl(ax25.SREJ, nr, false), false))
+	}
+	state := ac.state
+	a.mu.Unlock()
+	if state != stateConnected {
+		t.Fatalf("Expected to stay connected, got state %v", state)
+	}
+	if got := sentI(2); len(got) != 2 || got[0] != 2 || got[1] != 1 {
+		t.Fatalf("Expected frames 2 and 1 to be resent, got %v", got)
+	}
+}
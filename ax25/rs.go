@@ -0,0 +1,204 @@
+package ax25
+
+import "errors"
+
+// errUncorrectable is returned when a Reed-Solomon block has more
+// errors than its check bytes can correct.
+var errUncorrectable = errors.New("too many errors to correct")
+
+// Arithmetic in GF(2^8) with the primitive polynomial
+// x^8+x^4+x^3+x^2+1 (0x11d), used by both FX.25 and IL2P.  The
+// tables are built by an initializer rather than init so codes can
+// be set up in other package variables.
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]int) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+// gfPow returns alpha^n.
+func gfPow(n int) byte {
+	n %= 255
+	if n < 0 {
+		n += 255
+	}
+	return gfExp[n]
+}
+
+// polyEval evaluates a polynomial with coefficients from highest
+// degree to lowest at x.
+func polyEval(p []byte, x byte) byte {
+	var y byte
+	for _, c := range p {
+		y = gfMul(y, x) ^ c
+	}
+	return y
+}
+
+// rsCode is a Reed-Solomon code over GF(2^8) with the given number
+// of check bytes, whose generator's roots are consecutive powers of
+// alpha from alpha^fcr.  Blocks are data followed by check bytes,
+// highest degree first, and may be shortened below 255 bytes.
+type rsCode struct {
+	nroots, fcr int
+	// gen is the generator polynomial, highest degree first.
+	gen []byte
+}
+
+func newRSCode(nroots, fcr int) *rsCode {
+	gen := []byte{1}
+	for i := 0; i < nroots; i++ {
+		// Multiply by (x - alpha^(fcr+i)).
+		root := gfPow(fcr + i)
+		next := make([]byte, len(gen)+1)
+		for j, c := range gen {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, root)
+		}
+		gen = next
+	}
+	return &rsCode{nroots: nroots, fcr: fcr, gen: gen}
+}
+
+// encode returns the check bytes for data.
+func (r *rsCode) encode(data []byte) []byte {
+	rem := make([]byte, r.nroots)
+	for _, c := range data {
+		fb := c ^ rem[0]
+		copy(rem, rem[1:])
+		rem[r.nroots-1] = 0
+		if fb != 0 {
+			for j := range rem {
+				rem[j] ^= gfMul(fb, r.gen[j+1])
+			}
+		}
+	}
+	return rem
+}
+
+func (r *rsCode) syndromes(block []byte) ([]byte, bool) {
+	s := make([]byte, r.nroots)
+	clean := true
+	for i := range s {
+		s[i] = polyEval(block, gfPow(r.fcr+i))
+		if s[i] != 0 {
+			clean = false
+		}
+	}
+	return s, clean
+}
+
+// decode corrects a block (data and check bytes) in place, returning
+// how many bytes were corrected.
+func (r *rsCode) decode(block []byte) (int, error) {
+	n := len(block)
+	if n > 255 || n <= r.nroots {
+		return 0, errUncorrectable
+	}
+	s, clean := r.syndromes(block)
+	if clean {
+		return 0, nil
+	}
+
+	// Berlekamp-Massey finds the error locator, lowest degree
+	// first.
+	lambda, prev := []byte{1}, []byte{1}
+	l, m, b := 0, 1, byte(1)
+	for k := 0; k < r.nroots; k++ {
+		d := s[k]
+		for i := 1; i <= l && i < len(lambda); i++ {
+			d ^= gfMul(lambda[i], s[k-i])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		t := append([]byte{}, lambda...)
+		coef := gfDiv(d, b)
+		for len(lambda) < len(prev)+m {
+			lambda = append(lambda, 0)
+		}
+		for i, c := range prev {
+			lambda[i+m] ^= gfMul(coef, c)
+		}
+		if 2*l <= k {
+			l, prev, b, m = k+1-l, t, d, 1
+		} else {
+			m++
+		}
+	}
+	lambda = lambda[:l+1]
+	if 2*l > r.nroots {
+		return 0, errUncorrectable
+	}
+
+	// omega = s * lambda mod x^nroots, lowest degree first.
+	omega := make([]byte, r.nroots)
+	for i := range omega {
+		for j := 0; j <= i && j < len(lambda); j++ {
+			omega[i] ^= gfMul(lambda[j], s[i-j])
+		}
+	}
+
+	evalLow := func(p []byte, x byte) byte {
+		var y byte
+		for i := len(p) - 1; i >= 0; i-- {
+			y = gfMul(y, x) ^ p[i]
+		}
+		return y
+	}
+
+	// Chien search for the roots of lambda, then Forney for the
+	// error values.  The block is only changed if it all works out.
+	fixed := append([]byte{}, block...)
+	found := 0
+	for i := 0; i < n; i++ {
+		deg := n - 1 - i
+		xinv := gfPow(-deg)
+		if evalLow(lambda, xinv) != 0 {
+			continue
+		}
+		var deriv byte
+		for j := 1; j < len(lambda); j += 2 {
+			deriv ^= gfMul(lambda[j], gfPow(-deg*(j-1)))
+		}
+		if deriv == 0 {
+			return 0, errUncorrectable
+		}
+		e := gfMul(gfPow(deg*(1-r.fcr)), gfDiv(evalLow(omega, xinv), deriv))
+		fixed[i] ^= e
+		found++
+	}
+	if found != l {
+		return 0, errUncorrectable
+	}
+	if _, clean := r.syndromes(fixed); !clean {
+		return 0, errUncorrectable
+	}
+	copy(block, fixed)
+	return found, nil
+}
@@ -0,0 +1,76 @@
+package ax25
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(gfDiv(1, byte(a)), byte(a)); got != 1 {
+			t.Fatalf("Expected a * 1/a = 1 for %v, got %v", a, got)
+		}
+	}
+	if gfPow(255) != 1 || gfPow(-1) != gfPow(254) {
+		t.Fatalf("Powers of alpha don't wrap")
+	}
+}
+
+func TestRSCorrects(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, c := range []struct{ nroots, fcr, k int }{
+		{16, 1, 239}, {16, 1, 32}, {32, 1, 223}, {64, 1, 128}, {2, 0, 20}, {16, 0, 100},
+	} {
+		rs := newRSCode(c.nroots, c.fcr)
+		data := make([]byte, c.k)
+		r.Read(data)
+		block := append(append([]byte{}, data...), rs.encode(data)...)
+		if _, clean := rs.syndromes(block); !clean {
+			t.Fatalf("RS(%v,%v) encoded block has errors", len(block), c.k)
+		}
+
+		for errs := 0; errs <= c.nroots/2; errs++ {
+			bad := append([]byte{}, block...)
+			for _, i := range r.Perm(len(bad))[:errs] {
+				bad[i] ^= byte(1 + r.Intn(255))
+			}
+			n, err := rs.decode(bad)
+			if err != nil || n != errs {
+				t.Fatalf("RS(%v,%v) with %v errors: corrected %v, %v",
+					len(block), c.k, errs, n, err)
+			}
+			if !bytes.Equal(bad, block) {
+				t.Fatalf("RS(%v,%v) with %v errors wasn't corrected", len(block), c.k, errs)
+			}
+		}
+	}
+}
+
+func TestRSUncorrectable(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	rs := newRSCode(16, 1)
+	data := make([]byte, 100)
+	r.Read(data)
+	block := append(append([]byte{}, data...), rs.encode(data)...)
+
+	failed := 0
+	for trial := 0; trial < 50; trial++ {
+		bad := append([]byte{}, block...)
+		for _, i := range r.Perm(len(bad))[:12] {
+			bad[i] ^= byte(1 + r.Intn(255))
+		}
+		orig := append([]byte{}, bad...)
+		if _, err := rs.decode(bad); err != nil {
+			failed++
+			if !bytes.Equal(bad, orig) {
+				t.Fatalf("Failed decode changed the block")
+			}
+		}
+	}
+	// Too many errors can occasionally land on another codeword,
+	// but almost all should be caught.
+	if failed < 45 {
+		t.Errorf("Expected nearly all uncorrectable blocks to fail, only %v did", failed)
+	}
+}
@@ -0,0 +1,243 @@
+// Package digi implements an APRS digipeater following the New-N
+// paradigm.
+package digi
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+// maxPath is the most digipeater addresses an AX.25 frame may carry.
+const maxPath = 8
+
+// DefaultDupeWindow is the duplicate suppression window used when
+// none is configured.
+const DefaultDupeWindow = 30 * time.Second
+
+// maxDupes is the most repeated frames remembered for duplicate
+// suppression.
+const maxDupes = 4096
+
+// Config describes how a digipeater behaves.
+type Config struct {
+	// Call is the callsign the digipeater transmits as.
+	Call aprs.Address
+	// Aliases are additional addresses the digipeater answers to
+	// (e.g. a site name or RELAY).
+	Aliases []string
+	// MaxHops traps WIDEn-N requests asking for more than this
+	// many hops.  Zero means 2.
+	MaxHops int
+	// FillIn restricts the digipeater to WIDE1-1.
+	FillIn bool
+	// Preemptive allows answering to our call or an alias that
+	// appears later in the path, dropping the unused addresses
+	// before it.
+	Preemptive bool
+	// Viscous holds packets for this long before transmitting,
+	// dropping them if another digipeater is heard repeating them
+	// first.
+	Viscous time.Duration
+	// DupeWindow is how long a repeated packet suppresses
+	// duplicates.  Zero means DefaultDupeWindow.
+	DupeWindow time.Duration
+}
+
+func (c Config) maxHops() int {
+	if c.MaxHops == 0 {
+		return 2
+	}
+	return c.MaxHops
+}
+
+// callString is an address's string form with the repeated mark and
+// a zero SSID (as decoded from AX.25) removed.
+func callString(a aprs.Address) string {
+	a = a.Unrepeated()
+	if a.SSID == "0" {
+		a.SSID = ""
+	}
+	return a.String()
+}
+
+func (c Config) isMine(a aprs.Address) bool {
+	s := callString(a)
+	if s == callString(c.Call) {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if s == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWide parses a WIDEn-N address, returning n and N.
+func parseWide(a aprs.Address) (n, hops int, ok bool) {
+	a = a.Unrepeated()
+	if len(a.Call) != 5 || !strings.HasPrefix(a.Call, "WIDE") {
+		return 0, 0, false
+	}
+	n = int(a.Call[4] - '0')
+	hops, err := strconv.Atoi(a.SSID)
+	if a.SSID == "" {
+		hops, err = 0, nil
+	}
+	if err != nil || n < 1 || n > 7 || hops < 0 || hops > n {
+		return 0, 0, false
+	}
+	return n, hops, true
+}
+
+// nextHop returns the index of the first unused address in a path.
+func nextHop(path []aprs.Address) int {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].IsRepeated() {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func splice(path []aprs.Address, i, j int, with ...aprs.Address) []aprs.Address {
+	rv := make([]aprs.Address, 0, len(path)+len(with))
+	rv = append(rv, path[:i]...)
+	rv = append(rv, with...)
+	return append(rv, path[j:]...)
+}
+
+// Rewrite returns the frame as this digipeater would repeat it, or
+// false if it shouldn't be repeated at all.  It doesn't consider
+// duplicates.
+func (c Config) Rewrite(f aprs.Frame) (aprs.Frame, bool) {
+	if callString(f.Source) == callString(c.Call) {
+		return f, false
+	}
+	if _, qi := f.QConstruct(); qi >= 0 {
+		return f, false
+	}
+
+	i := nextHop(f.Path)
+	if i >= len(f.Path) {
+		return f, false
+	}
+
+	mycall := c.Call.Repeated()
+	a := f.Path[i]
+	switch n, hops, isWide := parseWide(a); {
+	case c.isMine(a):
+		f.Path = splice(f.Path, i, i+1, mycall)
+		return f, true
+	case isWide && hops > 0 && (!c.FillIn || n == 1):
+		used := aprs.Address{Call: a.Call}.Repeated()
+		switch {
+		case n > c.maxHops() || hops > c.maxHops():
+			// Trap excessive hops by using them all up here.
+			f.Path = splice(f.Path, i, i+1, mycall, used)
+		case hops == 1 && len(f.Path) >= maxPath:
+			f.Path = splice(f.Path, i, i+1, mycall)
+		case hops == 1:
+			f.Path = splice(f.Path, i, i+1, mycall, used)
+		case len(f.Path) >= maxPath:
+			f.Path = splice(f.Path, i, i+1,
+				aprs.Address{Call: a.Call, SSID: strconv.Itoa(hops - 1)})
+		default:
+			f.Path = splice(f.Path, i, i+1, mycall,
+				aprs.Address{Call: a.Call, SSID: strconv.Itoa(hops - 1)})
+		}
+		return f, true
+	case c.Preemptive:
+		for j := i + 1; j < len(f.Path); j++ {
+			if c.isMine(f.Path[j]) {
+				f.Path = splice(f.Path, i, j+1, mycall)
+				return f, true
+			}
+		}
+	}
+	return f, false
+}
+
+type held struct {
+	key string
+	at  time.Time
+	f   aprs.Frame
+}
+
+// A Digipeater decides which heard frames to repeat.
+//
+// Frames are given to Input as they're heard.  Frames that should be
+// transmitted right away are returned from Input, while frames held
+// for a viscous delay are returned from Due once the delay expires.
+type Digipeater struct {
+	Config
+
+	dupes *aprs.DupeDetector
+	mu    sync.Mutex
+	held  []held
+}
+
+// New creates a Digipeater with the given configuration.
+func New(c Config) *Digipeater {
+	if c.DupeWindow == 0 {
+		c.DupeWindow = DefaultDupeWindow
+	}
+	return &Digipeater{
+		Config: c,
+		dupes:  aprs.NewDupeDetector(c.DupeWindow, maxDupes),
+	}
+}
+
+// Input considers a frame heard at the given time.  It returns the
+// frame to transmit immediately, if any.
+func (d *Digipeater) Input(f aprs.Frame, now time.Time) (aprs.Frame, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	k := f.DupeKey()
+
+	// Hearing a held packet again means someone else repeated it.
+	for i, h := range d.held {
+		if h.key == k {
+			d.held = append(d.held[:i], d.held[i+1:]...)
+			break
+		}
+	}
+
+	if d.dupes.SeenAt(f, now) {
+		return f, false
+	}
+	out, ok := d.Rewrite(f)
+	if !ok {
+		return f, false
+	}
+	d.dupes.AddAt(f, now)
+	if d.Viscous > 0 {
+		d.held = append(d.held, held{k, now.Add(d.Viscous), out})
+		return f, false
+	}
+	return out, true
+}
+
+// Due returns held frames whose viscous delay has expired by the
+// given time.
+func (d *Digipeater) Due(now time.Time) []aprs.Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var rv []aprs.Frame
+	remaining := d.held[:0]
+	for _, h := range d.held {
+		if now.Before(h.at) {
+			remaining = append(remaining, h)
+		} else {
+			rv = append(rv, h.f)
+		}
+	}
+	d.held = remaining
+	return rv
+}
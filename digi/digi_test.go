@@ -0,0 +1,175 @@
+package digi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+var testConfig = Config{
+	Call:    aprs.AddressFromString("KG6HWF-2"),
+	Aliases: []string{"HILLTP"},
+}
+
+func TestRewrite(t *testing.T) {
+	fillin := testConfig
+	fillin.FillIn = true
+	preempt := testConfig
+	preempt.Preemptive = true
+
+	tests := []struct {
+		c   Config
+		in  string
+		exp string
+	}{
+		{testConfig, "N6WKZ>APRS,WIDE1-1,WIDE2-1:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE1*,WIDE2-1:>hi"},
+		{testConfig, "N6WKZ>APRS,WIDE2-2:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE2-1:>hi"},
+		{testConfig, "N6WKZ>APRS,WR6ABD*,WIDE2-1:>hi",
+			"N6WKZ>APRS,WR6ABD*,KG6HWF-2*,WIDE2*:>hi"},
+		{testConfig, "N6WKZ>APRS,WIDE1*,WIDE2-1:>hi",
+			"N6WKZ>APRS,WIDE1*,KG6HWF-2*,WIDE2*:>hi"},
+		{testConfig, "N6WKZ>APRS,HILLTP,WIDE2-1:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE2-1:>hi"},
+		{testConfig, "N6WKZ>APRS,KG6HWF-2,WIDE2-1:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE2-1:>hi"},
+		{testConfig, "N6WKZ>APRS,WIDE7-7:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE7*:>hi"},
+		{testConfig, "N6WKZ>APRS,WIDE2*:>hi", ""},
+		{testConfig, "N6WKZ>APRS:>hi", ""},
+		{testConfig, "N6WKZ>APRS,WR6ABD,WIDE2-1:>hi", ""},
+		{testConfig, "KG6HWF-2>APRS,WIDE2-1:>hi", ""},
+		{testConfig, "N6WKZ>APRS,WIDE2-1,qAR,N6ACK:>hi", ""},
+		{testConfig, "N6WKZ>APRS,A,B,C,D,E,F,G*,WIDE2-2:>hi",
+			"N6WKZ>APRS,A,B,C,D,E,F,G*,WIDE2-1:>hi"},
+		{testConfig, "N6WKZ>APRS,A,B,C,D,E,F,G*,WIDE2-1:>hi",
+			"N6WKZ>APRS,A,B,C,D,E,F,G*,KG6HWF-2*:>hi"},
+		{fillin, "N6WKZ>APRS,WIDE1-1,WIDE2-1:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE1*,WIDE2-1:>hi"},
+		{fillin, "N6WKZ>APRS,WIDE2-2:>hi", ""},
+		{preempt, "N6WKZ>APRS,WR6ABD,HILLTP,WIDE2-1:>hi",
+			"N6WKZ>APRS,KG6HWF-2*,WIDE2-1:>hi"},
+		{testConfig, "N6WKZ>APRS,WR6ABD,HILLTP,WIDE2-1:>hi", ""},
+	}
+
+	for _, test := range tests {
+		got, ok := test.c.Rewrite(aprs.ParseFrame(test.in))
+		if ok != (test.exp != "") {
+			t.Errorf("Rewrite(%v) = %v, %v; want %q", test.in, got, ok, test.exp)
+			continue
+		}
+		if ok && got.String() != test.exp {
+			t.Errorf("Rewrite(%v) = %v, want %v", test.in, got, test.exp)
+		}
+	}
+}
+
+func TestDupes(t *testing.T) {
+	d := New(testConfig)
+	now := time.Now()
+	f := aprs.ParseFrame("N6WKZ>APRS,WIDE2-2:>hi")
+
+	if _, ok := d.Input(f, now); !ok {
+		t.Fatalf("Expected to digipeat %v", f)
+	}
+	g := aprs.ParseFrame("N6WKZ>APRS,WR6ABD*,WIDE2-1:>hi \r")
+	if got, ok := d.Input(g, now.Add(time.Second)); ok {
+		t.Fatalf("Expected %v to be a dupe, got %v", g, got)
+	}
+	if _, ok := d.Input(f, now.Add(DefaultDupeWindow)); !ok {
+		t.Fatalf("Expected to digipeat %v after the dupe window", f)
+	}
+}
+
+func TestViscous(t *testing.T) {
+	c := testConfig
+	c.Viscous = 5 * time.Second
+	d := New(c)
+	now := time.Now()
+
+	f := aprs.ParseFrame("N6WKZ>APRS,WIDE1-1:>hi")
+	if _, ok := d.Input(f, now); ok {
+		t.Fatalf("Viscous digipeater transmitted %v immediately", f)
+	}
+	if due := d.Due(now.Add(time.Second)); len(due) != 0 {
+		t.Fatalf("Expected nothing due yet, got %v", due)
+	}
+	due := d.Due(now.Add(c.Viscous))
+	if len(due) != 1 || due[0].String() != "N6WKZ>APRS,KG6HWF-2*,WIDE1*:>hi" {
+		t.Fatalf("Expected held frame to be due, got %v", due)
+	}
+
+	g := aprs.ParseFrame("N6WKZ>APRS,WIDE1-1:>again")
+	d.Input(g, now)
+	d.Input(aprs.ParseFrame("N6WKZ>APRS,N6ACK*,WIDE1*:>again"), now.Add(time.Second))
+	if due := d.Due(now.Add(c.Viscous)); len(due) != 0 {
+		t.Fatalf("Expected frame repeated elsewhere to be dropped, got %v", due)
+	}
+}
+
+func TestTNCLog(t *testing.T) {
+	f, err := os.Open("../logs/tnc.log.gz")
+	if err != nil {
+		t.Fatalf("Error opening log: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Error reading log: %v", err)
+	}
+
+	d := New(testConfig)
+	now := time.Unix(1478026282, 0)
+	heard, repeated := 0, 0
+	s := bufio.NewScanner(gz)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		in := aprs.ParseFrame(line)
+		if !in.IsValid() {
+			continue
+		}
+		heard++
+		now = now.Add(10 * time.Second)
+		out, ok := d.Input(in, now)
+		if !ok {
+			continue
+		}
+		repeated++
+		if len(out.Path) > maxPath {
+			t.Errorf("Path too long repeating %v: %v", in, out)
+		}
+		if !strings.Contains(out.String(), ",KG6HWF-2*") {
+			t.Errorf("Didn't mark ourselves repeating %v: %v", in, out)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Error scanning log: %v", err)
+	}
+	if repeated == 0 || repeated == heard {
+		t.Fatalf("Repeated %v of %v heard frames", repeated, heard)
+	}
+	t.Logf("Repeated %v of %v heard frames", repeated, heard)
+}
+
+func TestRewriteDecoded(t *testing.T) {
+	f := aprs.Frame{
+		Source: aprs.Address{Call: "N6WKZ", SSID: "0"},
+		Dest:   aprs.Address{Call: "APRS", SSID: "0"},
+		Path:   []aprs.Address{{Call: "KG6HWF", SSID: "2"}, {Call: "WIDE2", SSID: "0"}},
+		Body:   ">hi",
+	}
+	got, ok := testConfig.Rewrite(f)
+	exp := "N6WKZ-0>APRS-0,KG6HWF-2*,WIDE2-0:>hi"
+	if !ok || got.String() != exp {
+		t.Fatalf("Expected %v, got %v, %v", exp, got, ok)
+	}
+}
@@ -0,0 +1,111 @@
+package aprs
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DupeKey is the identity of a frame for duplicate detection.
+//
+// As on APRS-IS, the path is ignored so copies of a packet that took
+// different routes match.  The destination SSID is ignored since
+// digipeaters may rewrite it, as is trailing whitespace in the body.
+func (d Frame) DupeKey() string {
+	src := d.Source.Unrepeated()
+	if src.SSID == "0" {
+		src.SSID = ""
+	}
+	return src.String() + ">" + d.Dest.Unrepeated().Call + ":" +
+		strings.TrimRight(string(d.Body), " \t\r\n")
+}
+
+type dupeEntry struct {
+	key string
+	at  time.Time
+}
+
+// A DupeDetector finds frames seen recently within a time window.
+//
+// It remembers at most a fixed number of frames, forgetting the
+// oldest first, and is safe for concurrent use.
+type DupeDetector struct {
+	window time.Duration
+	size   int
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []dupeEntry
+}
+
+// NewDupeDetector creates a DupeDetector that considers frames
+// duplicates for the given window, remembering at most size frames.
+func NewDupeDetector(window time.Duration, size int) *DupeDetector {
+	return &DupeDetector{
+		window: window,
+		size:   size,
+		seen:   map[string]time.Time{},
+	}
+}
+
+func (d *DupeDetector) forgetOldest() {
+	e := d.order[0]
+	d.order = d.order[1:]
+	if t, ok := d.seen[e.key]; ok && t.Equal(e.at) {
+		delete(d.seen, e.key)
+	}
+}
+
+func (d *DupeDetector) expire(now time.Time) {
+	for len(d.order) > 0 && now.Sub(d.order[0].at) >= d.window {
+		d.forgetOldest()
+	}
+}
+
+func (d *DupeDetector) seenAt(k string, now time.Time) bool {
+	d.expire(now)
+	t, ok := d.seen[k]
+	return ok && now.Sub(t) < d.window
+}
+
+func (d *DupeDetector) addAt(k string, now time.Time) {
+	for len(d.order) >= d.size && len(d.order) > 0 {
+		d.forgetOldest()
+	}
+	if len(d.order) == 0 {
+		// Let go of the space consumed by forgotten entries.
+		d.order = make([]dupeEntry, 0, 16)
+	}
+	d.seen[k] = now
+	d.order = append(d.order, dupeEntry{k, now})
+}
+
+// SeenAt is true if the frame was added within the window before
+// the given time.
+func (d *DupeDetector) SeenAt(f Frame, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seenAt(f.DupeKey(), now)
+}
+
+// AddAt records the frame as seen at the given time.
+func (d *DupeDetector) AddAt(f Frame, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.addAt(f.DupeKey(), now)
+}
+
+// IsDupe is true if the frame was seen within the window.  Frames
+// that aren't duplicates are recorded as seen now.
+func (d *DupeDetector) IsDupe(f Frame) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	k := f.DupeKey()
+	if d.seenAt(k, now) {
+		return true
+	}
+	d.addAt(k, now)
+	return false
+}
@@ -0,0 +1,96 @@
+package aprs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDupeKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{christmasMsg, "KG6HWF>APX200,TCPIP*,qAC,T2TEST:=3722.1 N/12159.1 W-Merry Christmas!", true},
+		{christmasMsg, christmasMsg + " \r", true},
+		{christmasMsg, "KG6HWF>APX200-2,WIDE2-1:=3722.1 N/12159.1 W-Merry Christmas!", true},
+		{christmasMsg, "KG6HWF-1>APX200,WIDE2-1:=3722.1 N/12159.1 W-Merry Christmas!", false},
+		{christmasMsg, "KG6HWF>APX201,WIDE2-1:=3722.1 N/12159.1 W-Merry Christmas!", false},
+		{christmasMsg, "KG6HWF>APX200,WIDE2-1: =3722.1 N/12159.1 W-Merry Christmas!", false},
+	}
+
+	for _, test := range tests {
+		a, b := ParseFrame(test.a).DupeKey(), ParseFrame(test.b).DupeKey()
+		if (a == b) != test.same {
+			t.Errorf("DupeKey(%q) = %q, DupeKey(%q) = %q, want same=%v",
+				test.a, a, test.b, b, test.same)
+		}
+	}
+}
+
+func TestDupeDetector(t *testing.T) {
+	d := NewDupeDetector(30*time.Second, 100)
+	now := time.Now()
+	f := ParseFrame(christmasMsg)
+
+	if d.SeenAt(f, now) {
+		t.Fatalf("Saw %v before adding it", f)
+	}
+	d.AddAt(f, now)
+	if !d.SeenAt(f, now.Add(29*time.Second)) {
+		t.Fatalf("Didn't see %v within the window", f)
+	}
+	if d.SeenAt(f, now.Add(30*time.Second)) {
+		t.Fatalf("Saw %v after the window", f)
+	}
+
+	if d.IsDupe(f) {
+		t.Fatalf("Expected %v not to be a dupe the first time", f)
+	}
+	if !d.IsDupe(f) {
+		t.Fatalf("Expected %v to be a dupe the second time", f)
+	}
+}
+
+func TestDupeDetectorBounded(t *testing.T) {
+	d := NewDupeDetector(time.Hour, 10)
+	now := time.Now()
+	frame := func(i int) Frame {
+		return ParseFrame(fmt.Sprintf("KG6HWF>APX200:>status %d", i))
+	}
+
+	for i := 0; i < 100; i++ {
+		d.AddAt(frame(i), now)
+	}
+	if len(d.seen) != 10 || len(d.order) != 10 {
+		t.Fatalf("Expected 10 remembered frames, got %v/%v", len(d.seen), len(d.order))
+	}
+	if d.SeenAt(frame(89), now) || !d.SeenAt(frame(90), now) {
+		t.Fatalf("Expected only the newest frames to be remembered")
+	}
+}
+
+func TestDupeDetectorConcurrent(t *testing.T) {
+	d := NewDupeDetector(time.Minute, 1000)
+	f := ParseFrame(christmasMsg)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	unique := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !d.IsDupe(f) {
+				mu.Lock()
+				unique++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if unique != 1 {
+		t.Fatalf("Expected exactly one non-dupe, got %v", unique)
+	}
+}
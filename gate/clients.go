@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+var (
+	isQueueSize    = flag.Int("is-queue", 1000, "Frames queued for each APRS-IS client before it's considered slow")
+	isWriteTimeout = flag.Duration("is-write-timeout", 30*time.Second, "Disconnect APRS-IS clients that block writes this long")
+	isSlowPolicy   = flag.String("is-slow-policy", "drop", "What to do when a client's queue is full (drop or disconnect)")
+	isKeepalive    = flag.Duration("is-keepalive", 20*time.Second, "How often to send APRS-IS clients a keepalive comment")
+	isLoginTimeout = flag.Duration("is-login-timeout", 30*time.Second, "Disconnect APRS-IS clients that don't log in within this long")
+	isIdleTimeout  = flag.Duration("is-idle-timeout", 48*time.Hour, "Disconnect APRS-IS clients that send nothing for this long")
+	isMaxClients   = flag.Int("is-max-clients", 200, "Most APRS-IS clients to accept at once")
+	isMaxPerIP     = flag.Int("is-max-per-ip", 10, "Most APRS-IS clients to accept at once from one address")
+)
+
+func init() {
+	http.HandleFunc("/is/clients", showISClients)
+}
+
+func (c *isClient) sent() uint64 {
+	return atomic.LoadUint64(&c.sentCount)
+}
+
+func (c *isClient) dropped() uint64 {
+	return atomic.LoadUint64(&c.droppedCount)
+}
+
+// enqueue queues a frame for the client without blocking.  When the
+// queue is full, the oldest queued frame is dropped to make room,
+// or false is returned if the client should be disconnected instead.
+func (c *isClient) enqueue(msg aprs.Frame) bool {
+	for {
+		select {
+		case c.queue <- msg:
+			return true
+		default:
+		}
+		if c.disconnectSlow {
+			return false
+		}
+		select {
+		case <-c.queue:
+			atomic.AddUint64(&c.droppedCount, 1)
+		default:
+		}
+	}
+}
+
+// writeLines writes queued frames to the client until the queue is
+// closed or a write fails.
+func (c *isClient) writeLines(done chan<- error) {
+	for msg := range c.queue {
+		if err := c.println(msg); err != nil {
+			done <- err
+			return
+		}
+		atomic.AddUint64(&c.sentCount, 1)
+	}
+}
+
+// keepalive periodically sends the client a comment naming the
+// server and time until stopped or a write fails.
+func (c *isClient) keepalive(stop <-chan bool, done chan<- error) {
+	t := time.NewTicker(*isKeepalive)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			err := c.comment("goaprs %v %v", now.UTC().Format("02 Jan 2006 15:04:05 GMT"), *isName)
+			if err != nil {
+				done <- err
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// connLimits counts connections in total and by address.
+type connLimits struct {
+	mu    sync.Mutex
+	total int
+	byIP  map[string]int
+}
+
+var isLimits = &connLimits{byIP: map[string]int{}}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// acquire counts a new connection from the given address, returning
+// why it's refused if it would exceed the limits.
+func (l *connLimits) acquire(addr net.Addr) string {
+	host := hostOf(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.total >= *isMaxClients {
+		return "server full"
+	}
+	if l.byIP[host] >= *isMaxPerIP {
+		return "too many connections from " + host
+	}
+	l.total++
+	l.byIP[host]++
+	return ""
+}
+
+// release forgets a connection counted by acquire.
+func (l *connLimits) release(addr net.Addr) {
+	host := hostOf(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.byIP[host]--; l.byIP[host] <= 0 {
+		delete(l.byIP, host)
+	}
+}
+
+// A clientList tracks the connected APRS-IS clients.
+type clientList struct {
+	mu      sync.Mutex
+	clients map[*isClient]bool
+}
+
+var isClients = &clientList{clients: map[*isClient]bool{}}
+
+func (l *clientList) add(c *isClient) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clients[c] = true
+}
+
+func (l *clientList) remove(c *isClient) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, c)
+}
+
+type isClientStats struct {
+	Login    string `json:"login"`
+	Addr     string `json:"addr"`
+	Verified bool   `json:"verified"`
+	Software string `json:"software"`
+	Sent     uint64 `json:"sent"`
+	Dropped  uint64 `json:"dropped"`
+	Queued   int    `json:"queued"`
+}
+
+func (l *clientList) stats() []isClientStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rv := []isClientStats{}
+	for c := range l.clients {
+		rv = append(rv, isClientStats{
+			Login:    c.login.String(),
+			Addr:     c.conn.RemoteAddr().String(),
+			Verified: c.verified,
+			Software: c.software,
+			Sent:     c.sent(),
+			Dropped:  c.dropped(),
+			Queued:   len(c.queue),
+		})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Login < rv[j].Login })
+	return rv
+}
+
+func showISClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(isClients.stats())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/digi"
+)
+
+var (
+	digiEnabled = flag.Bool("digi", false, "Digipeat frames heard on the radio")
+	digiAliases = flag.String("digi-aliases", "", "Comma separated aliases the digipeater answers to")
+	digiFillIn  = flag.Bool("digi-fillin", false, "Only digipeat WIDE1-1 (fill-in digipeater)")
+	digiPreempt = flag.Bool("digi-preemptive", false, "Answer to our call or aliases anywhere in the path")
+	digiViscous = flag.Duration("digi-viscous", 0, "Hold frames this long, dropping those repeated by others")
+	digiMaxHops = flag.Int("digi-maxhops", 2, "Trap WIDEn-N requests over this many hops")
+	digiDupes   = flag.Duration("digi-dupe-window", digi.DefaultDupeWindow, "Don't repeat a frame more than once in this long")
+)
+
+func newDigipeater() *digi.Digipeater {
+	if *call == "" {
+		log.Fatalf("Your callsign is required to digipeat.")
+	}
+	c := digi.Config{
+		Call:       aprs.AddressFromString(*call),
+		FillIn:     *digiFillIn,
+		Preemptive: *digiPreempt,
+		Viscous:    *digiViscous,
+		MaxHops:    *digiMaxHops,
+		DupeWindow: *digiDupes,
+	}
+	if *digiAliases != "" {
+		c.Aliases = strings.Split(*digiAliases, ",")
+	}
+	d := digi.New(c)
+	if c.Viscous > 0 {
+		go viscousDigipeat(d)
+	}
+	return d
+}
+
+func digipeat(msg aprs.Frame) {
+	if err := transmit(radio, msg); err != nil {
+		log.Printf("Error digipeating %v: %v", msg, err)
+	}
+}
+
+func viscousDigipeat(d *digi.Digipeater) {
+	for t := range time.Tick(time.Second) {
+		for _, msg := range d.Due(t) {
+			digipeat(msg)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-aprs"
+)
+
+// wildMatch is true if s matches pattern, where * in the pattern
+// matches any run of characters.
+func wildMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == '*' {
+			for i := len(s); i >= 0; i-- {
+				if wildMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(s) == 0 || pattern[0] != s[0] {
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// callList is a list of callsign patterns that may contain wildcards.
+type callList []string
+
+func (l callList) matches(s string) bool {
+	for _, p := range l {
+		if wildMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// thirdParty unwraps third-party traffic to the packet it carries.
+func thirdParty(d aprs.Frame) aprs.Frame {
+	for d.Body.Type().IsThirdParty() && len(d.Body) > 1 {
+		d = aprs.ParseFrame(string(d.Body[1:]))
+	}
+	return d
+}
+
+// objectName returns the name of an object or item, if the frame
+// is one.
+func objectName(d aprs.Frame) (string, bool) {
+	b := string(d.Body)
+	switch d.Body.Type() {
+	case ';':
+		if len(b) < 11 {
+			return "", false
+		}
+		return strings.TrimRight(b[1:10], " "), true
+	case ')':
+		if i := strings.IndexAny(b, "!_"); i > 1 {
+			return b[1:i], true
+		}
+	}
+	return "", false
+}
+
+// rangeFilter (r/lat/lon/dist) passes positions within dist km of a point.
+type rangeFilter struct {
+	center Point
+	dist   float64
+}
+
+func (f rangeFilter) Matches(d aprs.Frame) bool {
+	pos, err := thirdParty(d).Body.Position()
+	return err == nil && f.center.Distance(Point{pos.Lat, pos.Lon}) <= f.dist
+}
+
+// areaFilter (a/latN/lonW/latS/lonE) passes positions within a box.
+type areaFilter struct {
+	n, w, s, e float64
+}
+
+func (f areaFilter) Matches(d aprs.Frame) bool {
+	pos, err := thirdParty(d).Body.Position()
+	return err == nil && pos.Lat <= f.n && pos.Lat >= f.s &&
+		pos.Lon >= f.w && pos.Lon <= f.e
+}
+
+// prefixFilter (p/aa/bb) passes stations whose callsign starts with
+// any of the prefixes.
+type prefixFilter []string
+
+func (f prefixFilter) Matches(d aprs.Frame) bool {
+	src := d.Source.String()
+	for _, p := range f {
+		if strings.HasPrefix(src, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// budlistFilter (b/call1/call2) passes packets from the given stations.
+type budlistFilter callList
+
+func (f budlistFilter) Matches(d aprs.Frame) bool {
+	return callList(f).matches(d.Source.String())
+}
+
+// objectFilter (o/obj1/obj2) passes the named objects and items.
+type objectFilter callList
+
+func (f objectFilter) Matches(d aprs.Frame) bool {
+	name, ok := objectName(d)
+	return ok && callList(f).matches(name)
+}
+
+// typeFilter (t/poimqstunw) passes packets of the given types.
+type typeFilter string
+
+var nwsPrefixes = []string{"NWS", "SKY", "CWA", "BOM"}
+
+func isNWS(d aprs.Frame) bool {
+	m := d.Message()
+	for _, p := range nwsPrefixes {
+		if m.Parsed && strings.HasPrefix(m.Recipient.Call, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWeather(d aprs.Frame) bool {
+	switch d.Body.Type() {
+	case '_', '#', '*':
+		return true
+	}
+	pos, err := d.Body.Position()
+	return err == nil && pos.Symbol.Symbol == '_'
+}
+
+// packetTypeMatches is true if the frame is of the given t/ filter type.
+func packetTypeMatches(t byte, d aprs.Frame) bool {
+	switch t {
+	case 'p':
+		switch d.Body.Type() {
+		case '!', '=', '/', '@', '`', '\'', 0x1c, 0x1d:
+			return true
+		}
+	case 'o':
+		return d.Body.Type() == ';'
+	case 'i':
+		return d.Body.Type() == ')'
+	case 'm':
+		return d.Body.Type().IsMessage()
+	case 'q':
+		return d.Body.Type() == '?'
+	case 's':
+		return d.Body.Type() == '>'
+	case 't':
+		return d.Body.Type() == 'T'
+	case 'u':
+		return d.Body.Type() == '{'
+	case 'n':
+		return isNWS(d)
+	case 'w':
+		return isWeather(d)
+	}
+	return false
+}
+
+func (f typeFilter) Matches(d aprs.Frame) bool {
+	d = thirdParty(d)
+	for i := 0; i < len(f); i++ {
+		if packetTypeMatches(f[i], d) {
+			return true
+		}
+	}
+	return false
+}
+
+// symbolFilter (s/pri/alt/over) passes positions with the given
+// symbols from the primary or alternate table, optionally limited to
+// the given overlays.
+type symbolFilter struct {
+	pri, alt, over string
+}
+
+func (f symbolFilter) Matches(d aprs.Frame) bool {
+	pos, err := thirdParty(d).Body.Position()
+	if err != nil {
+		return false
+	}
+	sym := string(pos.Symbol.Symbol)
+	if pos.Symbol.Table == '/' {
+		return strings.Contains(f.pri, sym)
+	}
+	if !strings.Contains(f.alt, sym) {
+		return false
+	}
+	return f.over == "" || strings.ContainsRune(f.over, rune(pos.Symbol.Table))
+}
+
+// digiFilter (d/digi1/digi2) passes packets repeated by the given
+// digipeaters.
+type digiFilter callList
+
+func (f digiFilter) Matches(d aprs.Frame) bool {
+	used := -1
+	for i, a := range d.Path {
+		if a.IsQConstruct() {
+			break
+		}
+		if a.IsRepeated() {
+			used = i
+		}
+	}
+	for _, a := range d.Path[:used+1] {
+		if callList(f).matches(a.Unrepeated().String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryFilter (e/call1/call2) passes packets that entered APRS-IS
+// via the given igates or servers.
+type entryFilter callList
+
+func (f entryFilter) Matches(d aprs.Frame) bool {
+	g := d.QGate()
+	return g.Call != "" && callList(f).matches(g.String())
+}
+
+// groupFilter (g/call1/call2) passes messages to the given addressees.
+type groupFilter callList
+
+func (f groupFilter) Matches(d aprs.Frame) bool {
+	m := d.Message()
+	return m.Parsed && callList(f).matches(m.Recipient.String())
+}
+
+// unprotoFilter (u/unproto1) passes packets with the given
+// destination address.
+type unprotoFilter callList
+
+func (f unprotoFilter) Matches(d aprs.Frame) bool {
+	return callList(f).matches(d.Dest.String())
+}
+
+// qFilter (q/con/I) passes packets with the given q constructs.
+// With the I option, it also passes positions from stations seen
+// acting as igates.
+type qFilter struct {
+	cons   string
+	igates bool
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (f *qFilter) Matches(d aprs.Frame) bool {
+	q, _ := d.QConstruct()
+	if f.igates {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if q.Origin() == aprs.OriginRF {
+			f.seen[d.QGate().String()] = true
+		}
+		if f.seen[d.Source.String()] && packetTypeMatches('p', d) {
+			return true
+		}
+	}
+	return q != "" && strings.ContainsRune(f.cons, rune(q[2]))
+}
+
+// friendFilter (f/call/dist) passes packets within dist km of
+// another station's last known position.
+type friendFilter struct {
+	stations *stationStore
+	call     string
+	dist     float64
+}
+
+func (f friendFilter) Matches(d aprs.Frame) bool {
+	return f.stations.near(d, f.call, f.dist)
+}
+
+// myRangeFilter (m/dist) passes packets within dist km of the
+// client's own last known position.
+type myRangeFilter struct {
+	stations *stationStore
+	login    string
+	dist     float64
+}
+
+func (f myRangeFilter) Matches(d aprs.Frame) bool {
+	return f.stations.near(d, f.login, f.dist)
+}
+
+// typeRangeFilter (t/poimqstunw/call/km) passes packets of the given
+// types within km of a station's last known position.
+type typeRangeFilter struct {
+	types    typeFilter
+	stations *stationStore
+	call     string
+	dist     float64
+}
+
+func (f typeRangeFilter) Matches(d aprs.Frame) bool {
+	return f.types.Matches(d) && f.stations.near(d, f.call, f.dist)
+}
+
+// filterContext is what filters that depend on more than the frame
+// are evaluated against.
+type filterContext struct {
+	// stations provides last known positions.
+	stations *stationStore
+	// login is the callsign of the client the filter is for.
+	login string
+}
+
+func parseFloats(args []string, n int) ([]float64, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	rv := make([]float64, n)
+	for i, a := range args {
+		f, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return nil, err
+		}
+		rv[i] = f
+	}
+	return rv, nil
+}
+
+func needArgs(args []string) error {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "") {
+		return fmt.Errorf("missing arguments")
+	}
+	return nil
+}
+
+func (c filterContext) needStations() error {
+	if c.stations == nil {
+		return fmt.Errorf("station positions unavailable")
+	}
+	return nil
+}
+
+// parseFilterTerm parses a single filter term such as r/37/-122/50.
+func (c filterContext) parseFilterTerm(term string) (Filter, error) {
+	parts := strings.Split(term, "/")
+	args := parts[1:]
+	switch parts[0] {
+	case "f":
+		if err := c.needStations(); err != nil {
+			return nil, err
+		}
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+		}
+		v, err := parseFloats(args[1:], 1)
+		if err != nil {
+			return nil, err
+		}
+		return friendFilter{c.stations, args[0], v[0]}, nil
+	case "m":
+		if err := c.needStations(); err != nil {
+			return nil, err
+		}
+		if c.login == "" {
+			return nil, fmt.Errorf("not logged in")
+		}
+		v, err := parseFloats(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return myRangeFilter{c.stations, c.login, v[0]}, nil
+	case "r":
+		v, err := parseFloats(args, 3)
+		if err != nil {
+			return nil, err
+		}
+		return rangeFilter{Point{v[0], v[1]}, v[2]}, nil
+	case "a":
+		v, err := parseFloats(args, 4)
+		if err != nil {
+			return nil, err
+		}
+		return areaFilter{v[0], v[1], v[2], v[3]}, nil
+	case "s":
+		if len(args) < 1 || len(args) > 3 {
+			return nil, fmt.Errorf("expected 1 to 3 arguments, got %d", len(args))
+		}
+		f := symbolFilter{pri: args[0]}
+		if len(args) > 1 {
+			f.alt = args[1]
+		}
+		if len(args) > 2 {
+			f.over = args[2]
+		}
+		return f, nil
+	case "q":
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("expected 1 or 2 arguments, got %d", len(args))
+		}
+		f := &qFilter{cons: args[0], seen: map[string]bool{}}
+		if len(args) > 1 {
+			if args[1] != "I" {
+				return nil, fmt.Errorf("unknown q option %q", args[1])
+			}
+			f.igates = true
+		}
+		return f, nil
+	}
+
+	if err := needArgs(args); err != nil {
+		return nil, err
+	}
+	switch parts[0] {
+	case "p":
+		return prefixFilter(args), nil
+	case "b":
+		return budlistFilter(args), nil
+	case "o":
+		return objectFilter(args), nil
+	case "d":
+		return digiFilter(args), nil
+	case "e":
+		return entryFilter(args), nil
+	case "g":
+		return groupFilter(args), nil
+	case "u":
+		return unprotoFilter(args), nil
+	case "t":
+		if len(args) != 1 && len(args) != 3 {
+			return nil, fmt.Errorf("expected 1 or 3 arguments, got %d", len(args))
+		}
+		for _, r := range args[0] {
+			if !strings.ContainsRune("poimqstunw", r) {
+				return nil, fmt.Errorf("unknown packet type %q", r)
+			}
+		}
+		if len(args) == 1 {
+			return typeFilter(args[0]), nil
+		}
+		if err := c.needStations(); err != nil {
+			return nil, err
+		}
+		v, err := parseFloats(args[2:], 1)
+		if err != nil {
+			return nil, err
+		}
+		return typeRangeFilter{typeFilter(args[0]), c.stations, args[1], v[0]}, nil
+	}
+	return nil, fmt.Errorf("unknown filter type %q", parts[0])
+}
+
+// parseFilter parses an APRS-IS filter string such as
+// "r/37.3/-121.9/50 b/KG6HWF* -t/w" into a CompositeFilter.
+func (c filterContext) parseFilter(s string) (*CompositeFilter, error) {
+	rv := &CompositeFilter{}
+	for _, term := range strings.Fields(s) {
+		negative := strings.HasPrefix(term, "-")
+		f, err := c.parseFilterTerm(strings.TrimPrefix(term, "-"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %v", term, err)
+		}
+		if negative {
+			rv.Negative = append(rv.Negative, f)
+		} else {
+			rv.Positive = append(rv.Positive, f)
+		}
+	}
+	return rv, nil
+}
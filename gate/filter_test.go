@@ -0,0 +1,218 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/ax25"
+)
+
+const (
+	filterPosition = "KG6HWF>APX200,WIDE1-1,WIDE2-1,qAR,N6ACK-11:=3722.1 N/12159.1 W-Merry Christmas!"
+	filterRepeated = "WA6HCW-1>APRS,N6ACK-11,WIDE1*,WIDE2-1,qAR,KG6HWF:=3722.1 N/12159.1 W-hi"
+	filterWeather  = "WD6AGO>APU25N,WR6ABD*,qAR,KG6HWF:=3727.43N/12153.71W_Lillian's weather"
+	filterObject   = "KE6AFE-13>APKH2Z,TCPIP*,qAC,CORE-2:;VP@CM86XX*162000z3658.94N/12200.86W? KE6AFE-13 8800"
+	filterItem     = "KG6HWF>APRS,TCPIP*,qAC,T2TEST:)AID #2!4903.50N/07201.75WA"
+	filterMessage  = "KG6HWF-9>APDR12,TCPIP*,qAC,T2SPAIN2::KG6HWF   :testing notifications{10"
+	filterNWS      = "KG6HWF>APRS,TCPIP*,qAC,T2TEST::NWS-WARN :RED FLAG"
+	filterStatus   = "KG6HWF>APRS,TCPIP*,qAS,T2TEST:>status"
+	filterAlt      = "K6LRG-C>APJI23,WIDE1-1,WIDE2-1,qAR,W6MSU-7:!3729.98ND12152.33W&RNG0060 2m Voice"
+	filterThird    = "N6ACK-11>APRS,qAR,KG6HWF:}KG6HWF>APX200,TCPIP,N6ACK-11*:=/5L!!<*e7>7P["
+)
+
+func TestWildMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		exp        bool
+	}{
+		{"KG6HWF", "KG6HWF", true},
+		{"KG6HWF", "KG6HWF-9", false},
+		{"KG6HWF*", "KG6HWF-9", true},
+		{"KG6HWF*", "KG6HWF", true},
+		{"*-9", "KG6HWF-9", true},
+		{"K*-9", "KG6HWF-10", false},
+		{"*", "", true},
+		{"", "KG6HWF", false},
+	}
+
+	for _, test := range tests {
+		if got := wildMatch(test.pattern, test.s); got != test.exp {
+			t.Errorf("wildMatch(%q, %q) = %v, want %v", test.pattern, test.s, got, test.exp)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		matches []string
+		misses  []string
+	}{
+		{"r/37.37/-121.99/10", []string{filterPosition}, []string{filterObject, filterStatus}},
+		{"r/37.37/-121.99/100", []string{filterPosition, filterObject}, []string{filterStatus}},
+		{"a/38/-123/36/-121", []string{filterPosition, filterObject}, []string{filterItem}},
+		{"p/KG/WD", []string{filterPosition, filterWeather}, []string{filterObject}},
+		{"b/KG6HWF-9/WD6*", []string{filterMessage, filterWeather}, []string{filterPosition}},
+		{"o/VP@*/AID*", []string{filterObject, filterItem}, []string{filterPosition}},
+		{"t/o", []string{filterObject}, []string{filterItem, filterPosition}},
+		{"t/i", []string{filterItem}, []string{filterObject}},
+		{"t/p", []string{filterPosition, filterWeather}, []string{filterObject, filterStatus}},
+		{"t/m", []string{filterMessage, filterNWS}, []string{filterStatus}},
+		{"t/n", []string{filterNWS}, []string{filterMessage}},
+		{"t/s", []string{filterStatus}, []string{filterMessage}},
+		{"t/w", []string{filterWeather}, []string{filterPosition}},
+		{"s/-", []string{filterPosition}, []string{filterWeather, filterAlt}},
+		{"s//&", []string{filterAlt}, []string{filterPosition}},
+		{"s//&/D", []string{filterAlt}, nil},
+		{"s//&/X", nil, []string{filterAlt}},
+		{"r/49.5/-72.75/10", []string{filterThird}, []string{filterPosition}},
+		{"a/50/-73/49/-72", []string{filterThird}, []string{filterPosition}},
+		{"s/>", []string{filterThird}, []string{filterPosition}},
+		{"d/WR6ABD", []string{filterWeather}, []string{filterPosition}},
+		{"d/N6ACK*", []string{filterRepeated}, []string{filterPosition}},
+		{"d/WIDE1", []string{filterRepeated}, []string{filterPosition}},
+		{"e/N6ACK-11/CORE*", []string{filterPosition, filterObject}, []string{filterWeather}},
+		{"g/KG6HWF", []string{filterMessage}, []string{filterNWS}},
+		{"u/APU*", []string{filterWeather}, []string{filterPosition}},
+		{"q/C", []string{filterObject, filterMessage}, []string{filterPosition, filterStatus}},
+		{"q/RS", []string{filterPosition, filterStatus}, []string{filterMessage}},
+		{"p/K -p/KE", []string{filterPosition}, []string{filterObject}},
+		{"t/poimqstunw -t/w", []string{filterPosition, filterStatus}, []string{filterWeather}},
+		{"-p/K", nil, []string{filterPosition}},
+		{"", nil, []string{filterPosition}},
+	}
+
+	for _, test := range tests {
+		f, err := filterContext{}.parseFilter(test.filter)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.filter, err)
+			continue
+		}
+		for _, m := range test.matches {
+			if !f.Matches(aprs.ParseFrame(m)) {
+				t.Errorf("Expected %q to match %v", test.filter, m)
+			}
+		}
+		for _, m := range test.misses {
+			if f.Matches(aprs.ParseFrame(m)) {
+				t.Errorf("Expected %q not to match %v", test.filter, m)
+			}
+		}
+	}
+}
+
+func TestQFilterIGates(t *testing.T) {
+	f, err := filterContext{}.parseFilter("q//I")
+	if err != nil {
+		t.Fatalf("Error parsing filter: %v", err)
+	}
+	igatePos := aprs.ParseFrame("N6ACK-11>APMI06,TCPIP*,qAC,T2SJC:=3722.1 N/12159.1 W&igate")
+	if f.Matches(igatePos) {
+		t.Fatalf("Matched %v before it was seen as an igate", igatePos)
+	}
+	f.Matches(aprs.ParseFrame(filterPosition))
+	if !f.Matches(igatePos) {
+		t.Fatalf("Didn't match %v after it was seen as an igate", igatePos)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []string{
+		"x/1",
+		"r/37/-122",
+		"r/a/b/c",
+		"a/1/2/3",
+		"p",
+		"b/",
+		"t/z",
+		"t/p/KG6HWF",
+		"t/p/KG6HWF/10",
+		"f/KG6HWF/10",
+		"m/10",
+		"q/C/X",
+		"s/a/b/c/d",
+	}
+
+	for _, test := range tests {
+		if f, err := (filterContext{}).parseFilter(test); err == nil {
+			t.Errorf("Expected error parsing %q, got %#v", test, f)
+		}
+	}
+}
+
+func TestStationFilters(t *testing.T) {
+	s := newStationStore()
+	now := time.Now()
+	s.update(aprs.ParseFrame(filterPosition), now)
+	s.update(aprs.ParseFrame(filterObject), now)
+
+	c := filterContext{stations: s, login: "KG6HWF"}
+	mobile := "KG6HWF-9>APRS,TCPIP*,qAC,T2TEST:=3722.1 N/12159.1 W>mobile"
+	tests := []struct {
+		filter  string
+		matches []string
+		misses  []string
+	}{
+		{"f/KG6HWF/20", []string{filterPosition, filterWeather}, []string{filterObject}},
+		{"f/KG6HWF/100", []string{filterObject}, nil},
+		{"f/VP@CM86XX/100", nil, []string{filterPosition}},
+		{"m/20", []string{filterWeather, mobile}, []string{filterObject, filterMessage}},
+		{"t/w/KG6HWF/20", []string{filterWeather}, []string{filterPosition, filterObject}},
+		{"t/s/KG6HWF/20", []string{filterStatus}, []string{filterWeather}},
+	}
+
+	for _, test := range tests {
+		f, err := c.parseFilter(test.filter)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.filter, err)
+			continue
+		}
+		for _, m := range test.matches {
+			if !f.Matches(aprs.ParseFrame(m)) {
+				t.Errorf("Expected %q to match %v", test.filter, m)
+			}
+		}
+		for _, m := range test.misses {
+			if f.Matches(aprs.ParseFrame(m)) {
+				t.Errorf("Expected %q not to match %v", test.filter, m)
+			}
+		}
+	}
+
+	// Once KG6HWF moves away, its range moves with it.
+	f, err := c.parseFilter("m/20")
+	if err != nil {
+		t.Fatalf("Error parsing filter: %v", err)
+	}
+	s.update(aprs.ParseFrame("KG6HWF>APRS,TCPIP*,qAC,T2TEST:=4903.50N/07201.75W-moved"), now)
+	if f.Matches(aprs.ParseFrame(filterWeather)) {
+		t.Errorf("Still matched near the old position")
+	}
+	if !f.Matches(aprs.ParseFrame(filterItem)) {
+		t.Errorf("Didn't match near the new position")
+	}
+
+	// Stations heard on RF have SSID 0, but are asked for without.
+	rf, err := ax25.FrameFromAPRS(aprs.ParseFrame(
+		"WR6ABD>APRS:=4903.50N/07201.75W#digi"), false).APRS(true)
+	if err != nil {
+		t.Fatalf("Error converting from RF: %v", err)
+	}
+	if rf.Source.SSID != "0" {
+		t.Fatalf("Expected an RF source with SSID 0, got %v", rf.Source)
+	}
+	s.update(rf, now)
+	f, err = c.parseFilter("f/WR6ABD/20")
+	if err != nil {
+		t.Fatalf("Error parsing filter: %v", err)
+	}
+	if !f.Matches(aprs.ParseFrame(filterItem)) {
+		t.Errorf("Didn't match near a station heard on RF")
+	}
+
+	s.expire(now.Add(time.Second))
+	if _, ok := s.position("KG6HWF"); ok {
+		t.Errorf("Expected KG6HWF to have expired")
+	}
+}
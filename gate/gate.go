@@ -15,28 +15,74 @@ import (
 	"time"
 
 	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/afsk"
 	"github.com/dustin/go-aprs/aprsis"
 	"github.com/dustin/go-aprs/ax25"
+	"github.com/dustin/go-aprs/ax25/kisstcp"
+	"github.com/dustin/go-aprs/digi"
 	"github.com/dustin/go-broadcast"
 	"github.com/dustin/go-rs232"
 )
 
 var (
-	server     = flag.String("server", "second.aprs.net:14580", "APRS-IS upstream")
+	server     = flag.String("server", "second.aprs.net:14580", "APRS-IS upstream servers, comma separated")
 	httpAddr   = flag.String("http", ":7373", "HTTP bind address")
 	portString = flag.String("port", "", "Serial port KISS thing")
+	kissPort   = flag.Int("kiss-port", 0, "KISS TNC port to transmit on")
+	monitor    = flag.Bool("monitor", false, "Log every AX.25 frame heard on RF")
+	txDelay    = flag.Duration("kiss-txdelay", 0, "TXDELAY to set on the serial KISS TNC (0 leaves it alone)")
 	call       = flag.String("call", "", "Your callsign (for APRS-IS)")
 	pass       = flag.String("pass", "", "Your call pass (for APRS-IS)")
 	filter     = flag.String("filter", "", "Optional filter for APRS-IS server")
 	rawlog     = flag.String("rawlog", "", "Path to raw log messages")
 	wdTime     = flag.Duration("watchdog_time", 5*time.Minute, "Close connection if a message hasn't been heard in this long")
+	isName     = flag.String("is-name", "GOAPRS", "Server name reported to APRS-IS clients")
+	dupeWindow = flag.Duration("dupe-window", 30*time.Second, "Drop copies of a packet seen again within this long")
+
+	serverTLS      = flag.Bool("server-tls", false, "Connect to the APRS-IS upstream over TLS")
+	serverCA       = flag.String("server-ca", "", "PEM roots to verify the APRS-IS upstream with (default system roots)")
+	serverCert     = flag.String("server-cert", "", "PEM client certificate to present to the APRS-IS upstream")
+	serverKey      = flag.String("server-key", "", "PEM key for -server-cert")
+	serverInsecure = flag.Bool("server-insecure", false, "Don't verify the APRS-IS upstream's certificate")
+
+	audioPath    = flag.String("audio", "", "WAV file (or - for stdin) of AFSK 1200 audio to decode as RF")
+	audioRate    = flag.Int("audio-rate", 0, "Sample rate of raw 16-bit mono PCM -audio (0 expects WAV)")
+	audioSlicers = flag.Int("audio-slicers", 1, "Demodulator slicers to run on -audio (more help weak signals)")
+
+	baud      = flag.Int("baud", 57600, "Serial port speed for -port")
+	kissTCP   = flag.String("kiss-tcp", "", "host:port of a KISS TCP TNC (e.g. Direwolf on 8001) to use as the radio")
+	kissServe = flag.String("kiss-serve", "", "Address to share the radio with KISS TCP clients on (e.g. :8001)")
 )
 
+// A tnc takes KISS frames to transmit.
+type tnc interface {
+	WriteFrame(ax25.KISSFrame) error
+}
+
 var (
-	logWriter = io.Writer(ioutil.Discard)
-	radio     io.ReadWriteCloser
+	logWriter  = io.Writer(ioutil.Discard)
+	radio      tnc
+	kissServer *kisstcp.Server
 )
 
+// maxDupes is the most recent packets remembered for duplicate
+// detection.
+const maxDupes = 100000
+
+// dedupingBroadcaster drops frames that duplicate one submitted
+// recently, so copies heard on RF and from APRS-IS are merged.
+type dedupingBroadcaster struct {
+	broadcast.Broadcaster
+	dupes *aprs.DupeDetector
+}
+
+func (b *dedupingBroadcaster) Submit(m interface{}) {
+	if f, ok := m.(aprs.Frame); ok && b.dupes.IsDupe(f) {
+		return
+	}
+	b.Broadcaster.Submit(m)
+}
+
 func reporter(b broadcast.Broadcaster) {
 	ch := make(chan interface{})
 	b.Register(ch)
@@ -55,88 +101,231 @@ func reporter(b broadcast.Broadcaster) {
 	}
 }
 
-type loggingInfoHandler struct{}
+type loggingEventHandler struct{}
 
 var annoyinglog sync.Once
 
-func (*loggingInfoHandler) Info(msg string) {
-	// Ignore this annoying repetitive message
-	if strings.HasPrefix(msg, "# aprsc") {
+func (*loggingEventHandler) Event(e aprsis.Event) {
+	switch e.Type {
+	case aprsis.KeepaliveEvent:
+		// Ignore this annoying repetitive message
 		annoyinglog.Do(func() {
-			log.Printf("info: %s", msg)
+			log.Printf("info: %s", e.Raw)
 		})
-		return
+	case aprsis.LoginEvent:
+		log.Printf("APRS-IS login %v %v, server %v", e.Call, e.Status, e.Server)
+	default:
+		log.Printf("info: %s", e.Raw)
 	}
-	log.Printf("info: %s", msg)
-
 }
 
-func netClient(b broadcast.Broadcaster) error {
-	is, err := aprsis.Dial("tcp", *server)
+type loggingStateHandler struct{}
+
+func (loggingStateHandler) StateChanged(s aprsis.State, server string, err error) {
 	if err != nil {
-		return err
+		log.Printf("APRS-IS %v %v: %v", s, server, err)
+		return
 	}
+	log.Printf("APRS-IS %v %v", s, server)
+}
 
-	if err := is.Auth(*call, *pass, *filter); err != nil {
-		return err
+func readNet(b broadcast.Broadcaster) {
+	if *call == "" {
+		fmt.Fprintf(os.Stderr, "Your callsign is required.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *pass == "" {
+		fmt.Fprintf(os.Stderr, "Your call pass is required.\n")
+		flag.Usage()
+		os.Exit(1)
 	}
 
+	is := aprsis.NewClient(strings.Split(*server, ","), *call, *pass, *filter)
+	is.SetWatchdog(*wdTime)
+	is.SetEventHandler(&loggingEventHandler{})
+	is.SetStateHandler(loggingStateHandler{})
+	if *serverTLS {
+		config, err := aprsis.TLSConfig(*serverCA, *serverCert, *serverKey, *serverInsecure)
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		is.SetTLSConfig(config)
+	}
 	if *rawlog != "" {
-		logWriter, err := os.OpenFile(*rawlog,
+		w, err := os.OpenFile(*rawlog,
 			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 		if err != nil {
-			return err
+			log.Fatalf("Error opening raw log: %v", err)
 		}
-		is.SetRawLog(logWriter)
+		is.SetRawLog(w)
 	}
 
-	is.SetInfoHandler(&loggingInfoHandler{})
+	log.Fatalf("Error reading from APRS-IS: %v", netClient(is, b))
+}
 
-	wd := time.AfterFunc(*wdTime, func() { _ = is.Close() })
+// netClient submits frames from APRS-IS until the client fails.
+func netClient(is *aprsis.Client, b broadcast.Broadcaster) error {
 	for {
 		msg, err := is.Next()
 		if err != nil {
 			return err
 		}
 		b.Submit(msg)
-		wd.Reset(*wdTime)
 	}
 }
 
-func readNet(b broadcast.Broadcaster) {
-	if *call == "" {
-		fmt.Fprintf(os.Stderr, "Your callsign is required.\n")
-		flag.Usage()
-		os.Exit(1)
+// openSerial opens a hardware TNC on a serial port as the radio,
+// returning it and its frame reader.
+func openSerial() (tnc, func() (ax25.KISSFrame, error)) {
+	port, err := rs232.OpenPort(*portString, *baud, rs232.S_8N1)
+	if err != nil {
+		log.Fatalf("Error opening port: %s", err)
 	}
-	if *pass == "" {
-		fmt.Fprintf(os.Stderr, "Your call pass is required.\n")
-		flag.Usage()
-		os.Exit(1)
+	e := ax25.NewKISSEncoder(port)
+	if *txDelay > 0 {
+		if err := e.SetTXDelay(*kissPort, *txDelay); err != nil {
+			log.Fatalf("Error setting TXDELAY: %v", err)
+		}
+	}
+	return e, ax25.NewKISSDecoder(port).Next
+}
+
+type loggingKISSStateHandler struct{}
+
+func (loggingKISSStateHandler) StateChanged(connected bool, err error) {
+	switch {
+	case connected:
+		log.Printf("Connected to KISS TNC %v", *kissTCP)
+	case err != nil:
+		log.Printf("Disconnected from KISS TNC %v: %v", *kissTCP, err)
+	}
+}
+
+// openKISSTCP uses a soft TNC over KISS TCP as the radio, returning
+// it and its frame reader.
+func openKISSTCP() (tnc, func() (ax25.KISSFrame, error)) {
+	c := kisstcp.NewClient(*kissTCP)
+	c.SetStateHandler(loggingKISSStateHandler{})
+	return c, c.Next
+}
+
+// readKISS handles frames from a TNC, sharing them with any KISS TCP
+// clients.
+func readKISS(next func() (ax25.KISSFrame, error), b broadcast.Broadcaster) {
+	var digipeater *digi.Digipeater
+	if *digiEnabled {
+		digipeater = newDigipeater()
 	}
 
 	for {
-		err := netClient(b)
-		log.Printf("*** Error reading from net:  %v (restarting)", err)
-		time.Sleep(time.Second)
+		k, err := next()
+		if err != nil {
+			log.Fatalf("Error retrieving AX.25 frame via KISS: %v", err)
+		}
+		if k.Command != ax25.KISSData {
+			continue
+		}
+		if kissServer != nil {
+			kissServer.Broadcast(k)
+		}
+		f, err := ax25.DecodeFrame(k.Data)
+		if err != nil {
+			log.Printf("Ignoring malformed frame: %v", err)
+			continue
+		}
+		if *monitor {
+			log.Printf("RF port %v: %v", k.Port, f)
+		}
+		heardRF(f, digipeater, b)
 	}
 }
 
-func readSerial(b broadcast.Broadcaster) {
-	var err error
-	radio, err = rs232.OpenPort(*portString, 57600, rs232.S_8N1)
-	if err != nil {
-		log.Fatalf("Error opening port: %s", err)
+// serveKISS shares the radio with KISS TCP clients, transmitting the
+// data frames they send.
+func serveKISS() {
+	go func() {
+		for f := range kissServer.Frames() {
+			if f.Command != ax25.KISSData {
+				continue
+			}
+			if radio == nil {
+				log.Printf("No radio to transmit KISS client frame on")
+				continue
+			}
+			if err := radio.WriteFrame(f); err != nil {
+				log.Printf("Error transmitting KISS client frame: %v", err)
+			}
+		}
+	}()
+	log.Fatalf("Error serving KISS TCP: %v", kissServer.ListenAndServe(*kissServe))
+}
+
+// readAudio decodes frames from a recording or sound card capture
+// as if they'd been heard by the radio.
+func readAudio(b broadcast.Broadcaster) {
+	var r io.Reader = os.Stdin
+	if *audioPath != "-" {
+		f, err := os.Open(*audioPath)
+		if err != nil {
+			log.Fatalf("Error opening audio: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var pcm *afsk.PCMReader
+	if *audioRate > 0 {
+		pcm = afsk.NewPCMReader(r, *audioRate)
+	} else {
+		var err error
+		pcm, err = afsk.NewWAVReader(r)
+		if err != nil {
+			log.Fatalf("Error reading WAV header: %v", err)
+		}
 	}
 
-	d := ax25.NewDecoder(radio)
+	d, err := afsk.NewDecoder(pcm)
+	if err != nil {
+		log.Fatalf("Error decoding audio: %v", err)
+	}
+	d.SetSlicers(*audioSlicers)
 	for {
-		msg, err := d.Next()
+		f, err := d.NextFrame()
+		if err == io.EOF {
+			log.Printf("Finished decoding audio, %v bad frames", d.BadFCS())
+			return
+		}
 		if err != nil {
-			log.Fatalf("Error retrieving APRS message via KISS: %v", err)
+			log.Fatalf("Error reading audio: %v", err)
+		}
+		if *monitor {
+			log.Printf("Audio: %v", f)
+		}
+		heardRF(f, nil, b)
+	}
+}
+
+// heardRF handles a frame heard on RF, digipeating it if there's a
+// digipeater and submitting it if it's APRS.
+func heardRF(f ax25.Frame, digipeater *digi.Digipeater, b broadcast.Broadcaster) {
+	if !f.IsAPRS() {
+		return
+	}
+	msg, err := f.APRS(true)
+	if err != nil {
+		log.Printf("Error converting %v to APRS: %v", f, err)
+		return
+	}
+	noteRF(msg)
+	if digipeater != nil {
+		if out, ok := digipeater.Input(msg, time.Now()); ok {
+			digipeat(out)
 		}
-		b.Submit(msg)
 	}
+	if *call != "" && msg.IsGateable() {
+		msg = msg.IGated(aprs.AddressFromString(*call), true)
+	}
+	b.Submit(msg)
 }
 
 func main() {
@@ -146,6 +335,15 @@ func main() {
 	useSyslog := flag.Bool("syslog", false, "Log to syslog")
 	flag.Parse()
 
+	switch *isSlowPolicy {
+	case "drop", "disconnect":
+	default:
+		log.Fatalf("Invalid -is-slow-policy %q, must be drop or disconnect", *isSlowPolicy)
+	}
+	if *portString != "" && *kissTCP != "" {
+		log.Fatalf("Only one of -port and -kiss-tcp may be used")
+	}
+
 	if *useSyslog {
 		sl, err := syslog.New(syslog.LOG_INFO, "aprs-gate")
 		if err != nil {
@@ -155,20 +353,51 @@ func main() {
 		log.SetFlags(0)
 	}
 
-	broadcaster := broadcast.NewBroadcaster(100)
+	broadcaster := &dedupingBroadcaster{
+		Broadcaster: broadcast.NewBroadcaster(100),
+		dupes:       aprs.NewDupeDetector(*dupeWindow, maxDupes),
+	}
+
+	// The radio is set up before anything that might transmit on
+	// it starts, and never changes after.
+	var readRadio func() (ax25.KISSFrame, error)
+	switch {
+	case *portString != "":
+		radio, readRadio = openSerial()
+	case *kissTCP != "":
+		radio, readRadio = openKISSTCP()
+	}
 
 	// go reporter(broadcaster)
 	go notify(broadcaster)
+	go stations.run(broadcaster)
+	go rfHeard.run()
+	history = newHistoryRing(*historySize, *historyAge)
+	go history.run(broadcaster)
 
 	if *server != "" {
 		go readNet(broadcaster)
 	}
 
-	if *portString != "" {
-		go readSerial(broadcaster)
+	if *kissServe != "" {
+		kissServer = kisstcp.NewServer()
+		go serveKISS()
+	}
+	if readRadio != nil {
+		go readKISS(readRadio, broadcaster)
+	}
+	if *audioPath != "" {
+		go readAudio(broadcaster)
 	}
 
 	go startIS(serverNet, serverAddr, broadcaster)
 
+	if *submitHTTPAddr != "" {
+		go startHTTPSubmit(*submitHTTPAddr, broadcaster)
+	}
+	if *submitUDPAddr != "" {
+		go startUDPSubmit(*submitUDPAddr, broadcaster)
+	}
+
 	log.Fatal(http.ListenAndServe(*httpAddr, nil))
 }
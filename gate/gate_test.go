@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-aprs/aprsis"
+	"github.com/dustin/go-aprs/aprsis/aprsistest"
+	"github.com/dustin/go-broadcast"
+)
+
+func TestNetClient(t *testing.T) {
+	packets, err := aprsistest.ReadLog("../logs/igate.log.gz")
+	if err != nil {
+		t.Fatalf("Error reading log: %v", err)
+	}
+	packets = packets[:100]
+
+	s := aprsistest.NewServer()
+	defer s.Close()
+
+	b := broadcast.NewBroadcaster(100)
+	ch := make(chan interface{}, len(packets))
+	b.Register(ch)
+
+	is := aprsis.NewClient([]string{s.Addr}, "KG6HWF", "22955", "r/37/-122/50")
+	is.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	errs := make(chan error, 1)
+	go func() { errs <- netClient(is, b) }()
+
+	conn, err := s.Accept()
+	if err != nil {
+		t.Fatalf("Error waiting for login: %v", err)
+	}
+	if !conn.Verified || conn.Filter != "r/37/-122/50" {
+		t.Errorf("Unexpected login: %q", conn.Login)
+	}
+
+	// Half the packets, a dropped connection, then the rest.
+	conn.Send(packets[:50]...)
+	for _, p := range packets[:50] {
+		expectSubmitted(t, ch, p)
+	}
+	conn.Disconnect()
+	if conn, err = s.Accept(); err != nil {
+		t.Fatalf("Error waiting for login after disconnect: %v", err)
+	}
+	conn.Send(packets[50:]...)
+	for _, p := range packets[50:] {
+		expectSubmitted(t, ch, p)
+	}
+
+	is.Close()
+	select {
+	case err := <-errs:
+		if err != aprsis.ErrClosed {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for netClient to return")
+	}
+}
+
+func TestServeIS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	b := broadcast.NewBroadcaster(100)
+	served := make(chan error, 1)
+	go func() { served <- serveIS(ln, b) }()
+
+	// Our own client library against our own server.
+	is := aprsis.NewClient([]string{ln.Addr().String()}, "KG6HWF", "22955", "p/KE")
+	defer is.Close()
+	events := make(chan aprsis.Event, 10)
+	is.SetEventHandler(eventChan(events))
+
+	frames := make(chan aprs.Frame)
+	go func() {
+		for {
+			f, err := is.Next()
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- f
+		}
+	}()
+
+	for e := range events {
+		if e.Type == aprsis.LoginEvent {
+			if e.Status != aprsis.LoginVerified || e.Server != "GOAPRS" {
+				t.Fatalf("Unexpected login response: %+v", e)
+			}
+			break
+		}
+	}
+	// Wait for the filter to be installed before sending traffic.
+	for e := range events {
+		if e.Raw == "# filter p/KE active" {
+			break
+		}
+	}
+
+	b.Submit(aprs.ParseFrame(filterPosition))
+	b.Submit(aprs.ParseFrame(filterObject))
+	select {
+	case f := <-frames:
+		if f.String() != filterObject {
+			t.Errorf("Expected %v, got %v", filterObject, f)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for a frame")
+	}
+
+	ln.Close()
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected serveIS to stop when closed, got %v", err)
+	}
+}
+
+type eventChan chan aprsis.Event
+
+// Event drops events nobody is waiting for, rather than blocking the
+// client once the test has stopped reading them.
+func (c eventChan) Event(e aprsis.Event) {
+	select {
+	case c <- e:
+	default:
+	}
+}
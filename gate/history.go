@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-broadcast"
+)
+
+var (
+	historyAge  = flag.Duration("is-history", 30*time.Minute, "How far back to replay traffic to newly connected APRS-IS clients")
+	historySize = flag.Int("is-history-size", 10000, "Most recent frames kept for replay to APRS-IS clients")
+)
+
+type historyEntry struct {
+	frame aprs.Frame
+	at    time.Time
+}
+
+// A historyRing keeps the most recent frames seen for replay to
+// newly connected clients.
+type historyRing struct {
+	mu      sync.Mutex
+	entries []historyEntry
+	next    int
+	age     time.Duration
+}
+
+// history is set up by main once flags are parsed.
+var history *historyRing
+
+func newHistoryRing(size int, age time.Duration) *historyRing {
+	return &historyRing{entries: make([]historyEntry, 0, size), age: age}
+}
+
+func (h *historyRing) add(d aprs.Frame, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := historyEntry{d, now}
+	if len(h.entries) < cap(h.entries) {
+		h.entries = append(h.entries, e)
+		return
+	}
+	if len(h.entries) == 0 {
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// objectKilled is true if the frame is an object or item report
+// deleting it.
+func objectKilled(d aprs.Frame) bool {
+	name, ok := objectName(d)
+	if !ok {
+		return false
+	}
+	i := len(name) + 1
+	if d.Body.Type() == ';' {
+		i = 10
+	}
+	return len(d.Body) > i && d.Body[i] == '_'
+}
+
+// replay returns the frames worth sending a client that just logged
+// in as login: the last position of each station, objects that
+// haven't been killed, and messages to the client.  Frames are
+// returned oldest first.
+func (h *historyRing) replay(login string, now time.Time) []aprs.Frame {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := map[string]bool{}
+	var rv []aprs.Frame
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		e := h.entries[(h.next+i)%len(h.entries)]
+		if now.Sub(e.at) > h.age {
+			break
+		}
+		d := thirdParty(e.frame)
+		var key string
+		if name, ok := objectName(d); ok {
+			key = "obj:" + name
+		} else if m := d.Message(); m.Parsed {
+			if m.Recipient.String() == login {
+				rv = append(rv, e.frame)
+			}
+			continue
+		} else if _, err := d.Body.Position(); err == nil {
+			key = "pos:" + d.Source.String()
+		} else {
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if !objectKilled(d) {
+			rv = append(rv, e.frame)
+		}
+	}
+
+	for i, j := 0, len(rv)-1; i < j; i, j = i+1, j-1 {
+		rv[i], rv[j] = rv[j], rv[i]
+	}
+	return rv
+}
+
+func (h *historyRing) run(b broadcast.Broadcaster) {
+	ch := make(chan interface{}, 100)
+	b.Register(ch)
+	defer b.Unregister(ch)
+
+	for msgi := range ch {
+		h.add(msgi.(aprs.Frame), time.Now())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-broadcast"
+)
+
+func TestHistoryReplay(t *testing.T) {
+	h := newHistoryRing(6, time.Hour)
+	start := time.Now()
+	for i, s := range []string{
+		"OLD>APRS:!3722.20N/12159.00W-gone",
+		"KG6HWF>APRS:!3722.20N/12159.00W-first",
+		"N6ACK>APRS::KG6HWF   :hello{1",
+		"W6ABC>APRS:;LEADER   *092345z4903.50N/07201.75W>obj",
+		"KG6HWF>APRS:>status",
+		"N6ACK>APRS::N0CALL   :not for us",
+		"KG6HWF>APRS:!3722.20N/12159.00W-second",
+		"W6ABC>APRS:;DEAD     *092345z4903.50N/07201.75W>obj",
+		"W6ABC>APRS:;DEAD     _092345z4903.50N/07201.75W>obj",
+	} {
+		h.add(aprs.ParseFrame(s), start.Add(time.Duration(i)*time.Minute))
+	}
+
+	var got []string
+	for _, f := range h.replay("KG6HWF", start.Add(10*time.Minute)) {
+		got = append(got, f.String())
+	}
+	exp := []string{
+		"W6ABC>APRS:;LEADER   *092345z4903.50N/07201.75W>obj",
+		"KG6HWF>APRS:!3722.20N/12159.00W-second",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+
+	if got := h.replay("KG6HWF", start.Add(2*time.Hour)); len(got) != 0 {
+		t.Errorf("Expected old frames to be skipped, got %v", got)
+	}
+
+	var nilRing *historyRing
+	if got := nilRing.replay("KG6HWF", start); got != nil {
+		t.Errorf("Expected nothing from a nil history, got %v", got)
+	}
+}
+
+func TestHistoryMessages(t *testing.T) {
+	h := newHistoryRing(10, time.Hour)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		h.add(aprs.ParseFrame(fmt.Sprintf("N6ACK>APRS::KG6HWF   :msg%d", i)), now)
+	}
+	if got := h.replay("KG6HWF", now); len(got) != 3 {
+		t.Errorf("Expected all messages to be replayed, got %v", got)
+	}
+}
+
+func TestISReplay(t *testing.T) {
+	defer func(h *historyRing) { history = h }(history)
+	history = newHistoryRing(10, time.Hour)
+	history.add(aprs.ParseFrame(filterPosition), time.Now())
+	history.add(aprs.ParseFrame(filterObject), time.Now())
+
+	client, r := isTestClient(t, broadcast.NewBroadcaster(100))
+	defer client.Close()
+	fmt.Fprintf(client, "user KG6HWF pass -1 vers test 1.0 filter p/KE\r\n")
+	expectLine(t, r, "# logresp KG6HWF unverified, server GOAPRS")
+	expectLine(t, r, "# filter p/KE active")
+	expectLine(t, r, filterObject)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+)
+
+var (
+	rfMessages = flag.Bool("rf-messages", false, "Gate messages from APRS-IS clients to stations heard on RF")
+	rfHeardFor = flag.Duration("rf-heard-time", time.Hour, "How long a station heard on RF is considered local")
+)
+
+// rfGatePath is the path used for traffic gated to RF.
+var rfGatePath = []aprs.Address{aprs.AddressFromString("WIDE2-1")}
+
+// A heardList tracks when stations were last heard.
+type heardList struct {
+	mu    sync.Mutex
+	heard map[string]time.Time
+}
+
+var rfHeard = &heardList{heard: map[string]time.Time{}}
+
+func (h *heardList) add(call string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.heard[call] = now
+}
+
+func (h *heardList) recently(call string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.heard[call]
+	return ok && now.Sub(t) <= *rfHeardFor
+}
+
+// expire forgets stations not heard since before the given time.
+func (h *heardList) expire(before time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, t := range h.heard {
+		if t.Before(before) {
+			delete(h.heard, k)
+		}
+	}
+}
+
+// run expires stations no longer considered local.  Until then
+// recently ignores them anyway.
+func (h *heardList) run() {
+	for now := range time.Tick(time.Hour) {
+		h.expire(now.Add(-*rfHeardFor))
+	}
+}
+
+// noteRF records that a frame was heard on RF.
+func noteRF(msg aprs.Frame) {
+	src := msg.Source.Unrepeated()
+	if src.SSID == "0" {
+		src.SSID = ""
+	}
+	rfHeard.add(src.String(), time.Now())
+}
+
+// thirdPartyRF wraps a frame from APRS-IS as third-party traffic to
+// transmit from the given igate.
+func thirdPartyRF(igate aprs.Address, msg aprs.Frame) aprs.Frame {
+	inner := aprs.Frame{
+		Source: msg.Source,
+		Dest:   msg.Dest,
+		Path: []aprs.Address{aprs.AddressFromString("TCPIP"),
+			igate.Repeated()},
+		Body: msg.Body,
+	}
+	return aprs.Frame{
+		Source: igate,
+		Dest:   aprs.AddressFromString("APRS"),
+		Path:   rfGatePath,
+		Body:   aprs.Info("}" + inner.String()),
+	}
+}
+
+// gateMessageToRF transmits a message from APRS-IS if it's addressed
+// to a station recently heard on RF.
+func gateMessageToRF(msg aprs.Frame) {
+	if !*rfMessages || radio == nil || *call == "" {
+		return
+	}
+	m := msg.Message()
+	if !m.Parsed || !rfHeard.recently(m.Recipient.String(), time.Now()) {
+		return
+	}
+	out := thirdPartyRF(aprs.AddressFromString(*call), msg)
+	if err := transmit(radio, out); err != nil {
+		log.Printf("Error gating %v to RF: %v", msg, err)
+	}
+}
@@ -14,7 +14,6 @@ import (
 	"github.com/dustin/go-aprs"
 	"github.com/dustin/go-broadcast"
 	"github.com/dustin/go-nma"
-	"github.com/pmylund/go-cache"
 	"github.com/rem7/goprowl"
 )
 
@@ -138,7 +137,7 @@ func notify(b broadcast.Broadcaster) {
 	b.Register(ch)
 	defer b.Unregister(ch)
 
-	c := cache.New(time.Hour, time.Minute)
+	dupes := aprs.NewDupeDetector(time.Hour, maxDupes)
 
 	for msgi := range ch {
 		msg := msgi.(aprs.Frame)
@@ -146,16 +145,12 @@ func notify(b broadcast.Broadcaster) {
 		for msg.Body.Type().IsThirdParty() && len(msg.Body) > 1 {
 			msg = aprs.ParseFrame(string(msg.Body[1:]))
 		}
-		k := fmt.Sprintf("%v %v %v", msg.Dest, msg.Source, msg.Body)
 
-		_, found := c.Get(k)
-		if found {
+		if dupes.IsDupe(msg) {
 			// Already processed this one.
 			continue
 		}
 
-		c.Set(k, "hi", 0)
-
 		note := notification{msg.Body.Type().String(), fmt.Sprintf("%s: %s", sender, msg.Body)}
 		m := msg.Message()
 		if m.Parsed {
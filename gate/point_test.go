@@ -15,3 +15,10 @@ func TestPointDistance(t *testing.T) {
 		t.Fatalf("Expected %v, got %v", exp, d)
 	}
 }
+
+func TestPointDistanceSame(t *testing.T) {
+	p := Point{37.3691667, -121.985833}
+	if d := p.Distance(p); math.IsNaN(d) || d > .001 {
+		t.Fatalf("Expected 0 distance to self, got %v", d)
+	}
+}
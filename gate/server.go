@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dustin/go-aprs"
 	"github.com/dustin/go-broadcast"
@@ -64,27 +71,335 @@ func (p Point) RadLon() float64 {
 // Distance returns the approximate distance from another point in kilometers.
 func (p Point) Distance(p2 Point) float64 {
 	r := 6371.01
-	return math.Acos((math.Sin(p.RadLat())*
-		math.Sin(p2.RadLat()))+
-		(math.Cos(p.RadLat())*math.Cos(p2.RadLat())*
-			math.Cos(p.RadLon()-p2.RadLon()))) * r
+	c := (math.Sin(p.RadLat()) * math.Sin(p2.RadLat())) +
+		(math.Cos(p.RadLat()) * math.Cos(p2.RadLat()) *
+			math.Cos(p.RadLon()-p2.RadLon()))
+	// Rounding can push nearby points just past the domain of Acos.
+	return math.Acos(math.Max(-1, math.Min(1, c))) * r
 }
 
-func handleIS(conn net.Conn, b broadcast.Broadcaster) {
-	ch := make(chan interface{}, 100)
+// An isClient is a connection to our APRS-IS server.
+type isClient struct {
+	// Counters, updated atomically.
+	sentCount    uint64
+	droppedCount uint64
+
+	conn     net.Conn
+	r        *textproto.Reader
+	b        broadcast.Broadcaster
+	login    aprs.Address
+	verified bool
+	software string
+
+	// uplinked remembers what the client sent so it isn't echoed
+	// back.
+	uplinked *aprs.DupeDetector
+	// queue holds frames waiting to be written to the client.
+	queue chan aprs.Frame
+	// disconnectSlow is true if the client is disconnected rather
+	// than losing frames when its queue is full.
+	disconnectSlow bool
+
+	wmu sync.Mutex
+
+	mu     sync.Mutex
+	filter Filter
+}
+
+// A loginLine is the parsed form of a client's
+// "user CALL pass NNNN vers SOFTWARE VERSION filter ..." line.
+type loginLine struct {
+	user, pass, software, filter string
+}
+
+func parseLogin(line string) (rv loginLine, err error) {
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		arg := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch strings.ToLower(fields[i]) {
+		case "user":
+			rv.user = arg()
+		case "pass":
+			rv.pass = arg()
+		case "vers":
+			rv.software = arg()
+			if v := arg(); v != "" {
+				rv.software += " " + v
+			}
+		case "filter":
+			rv.filter = strings.Join(fields[i+1:], " ")
+			i = len(fields)
+		}
+	}
+	if rv.user == "" {
+		err = fmt.Errorf("no user in login %q", line)
+	}
+	return
+}
+
+// verifyPass is true if pass is the APRS-IS passcode for the call.
+func verifyPass(call aprs.Address, pass string) bool {
+	return call.Call != "" && pass == strconv.Itoa(int(call.CallPass()))
+}
+
+// println writes a line to the client.
+func (c *isClient) println(line interface{}) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(*isWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(c.conn, line)
+	return err
+}
+
+// comment writes a # comment line to the client.
+func (c *isClient) comment(format string, args ...interface{}) error {
+	return c.println("# " + fmt.Sprintf(format, args...))
+}
+
+// setFilter parses and installs a new filter for this client,
+// replacing the previous one.
+func (c *isClient) setFilter(s string) error {
+	ctx := filterContext{stations: stations, login: c.login.String()}
+	f, err := ctx.parseFilter(s)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter = f
+	return nil
+}
+
+// matches is true if this client wants the frame.  Clients that
+// haven't set a filter get everything.
+func (c *isClient) matches(d aprs.Frame) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filter == nil || c.filter.Matches(d)
+}
+
+func (c *isClient) updateFilter(s string) {
+	if err := c.setFilter(s); err != nil {
+		log.Printf("Invalid filter from %v: %v", c.login, err)
+		c.comment("invalid filter: %v", err)
+		return
+	}
+	c.comment("filter %s active", s)
+}
+
+// readLogin waits for the client's login line and responds to it.
+func (c *isClient) readLogin() error {
+	for {
+		line, err := c.r.ReadLine()
+		if err != nil {
+			return err
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		l, err := parseLogin(line)
+		if err != nil {
+			c.comment("login required")
+			return err
+		}
+		c.login = aprs.AddressFromString(l.user)
+		c.verified = verifyPass(c.login, l.pass)
+		c.software = l.software
+
+		status := "unverified"
+		if c.verified {
+			status = "verified"
+		}
+		log.Printf("APRS-IS login from %v (%v) %v using %v",
+			c.login, c.conn.RemoteAddr(), status, c.software)
+		if err := c.comment("logresp %v %v, server %v", c.login, status, *isName); err != nil {
+			return err
+		}
+		if l.filter != "" {
+			c.updateFilter(l.filter)
+		}
+		return nil
+	}
+}
+
+// hasTCPIP is true if the frame's path says it came from the
+// internet.
+func hasTCPIP(d aprs.Frame) bool {
+	for _, a := range d.Path {
+		switch a.Unrepeated().Call {
+		case "TCPIP", "TCPXX":
+			return true
+		}
+	}
+	return false
+}
 
-	_, err := fmt.Fprintf(conn, "# goaprs\n")
+// errUnverified is returned for packets from clients that didn't
+// log in with a valid passcode.
+var errUnverified = errors.New("unverified login")
+
+// acceptPacket validates a packet sent by a logged in client and
+// applies q processing to it.
+func acceptPacket(line string, conn aprs.QConn) (aprs.Frame, error) {
+	if !conn.Verified {
+		return aprs.Frame{}, errUnverified
+	}
+	msg := aprs.ParseFrame(line)
+	if !msg.IsValid() || msg.Source.Call == "" || msg.Dest.Call == "" {
+		return aprs.Frame{}, errors.New("invalid packet")
+	}
+
+	_, qi := msg.QConstruct()
+	if qi < 0 && !conn.UDP && !hasTCPIP(msg) && msg.Source.String() == conn.Login.String() {
+		msg.Path = append(msg.Path, aprs.AddressFromString("TCPIP*"))
+	}
+	return msg.QProcess(aprs.AddressFromString(*isName), conn)
+}
+
+// uplink validates a packet sent by the client and submits it.
+func (c *isClient) uplink(line string) error {
+	msg, err := acceptPacket(line, aprs.QConn{Login: c.login, Verified: c.verified})
 	if err != nil {
+		return err
+	}
+
+	c.uplinked.AddAt(msg, time.Now())
+	c.b.Submit(msg)
+	gateMessageToRF(msg)
+	return nil
+}
+
+// handleLine handles a line the client sent after logging in.
+func (c *isClient) handleLine(line string) {
+	if line == "" {
+		return
+	}
+	if line[0] != '#' {
+		if err := c.uplink(line); err != nil {
+			log.Printf("Rejected packet from %v: %v: %q", c.login, err, line)
+			c.comment("packet rejected: %v", err)
+		}
+		return
+	}
+	cmd := strings.TrimSpace(line[1:])
+	if strings.HasPrefix(cmd, "filter") {
+		c.updateFilter(strings.TrimSpace(cmd[len("filter"):]))
+	}
+}
+
+func (c *isClient) readLines(done chan<- error) {
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(*isIdleTimeout)); err != nil {
+			done <- err
+			return
+		}
+		line, err := c.r.ReadLine()
+		if err != nil {
+			done <- err
+			return
+		}
+		c.handleLine(line)
+	}
+}
+
+// unregister removes a channel from the broadcaster, draining it
+// meanwhile so the broadcaster can't block delivering to it.
+func unregister(b broadcast.Broadcaster, ch chan interface{}) {
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ch:
+			case <-done:
+				return
+			}
+		}
+	}()
+	b.Unregister(ch)
+	close(done)
+}
+
+func handleIS(conn net.Conn, b broadcast.Broadcaster) {
+	defer conn.Close()
+	c := &isClient{
+		conn:     conn,
+		r:        textproto.NewReader(bufio.NewReader(conn)),
+		b:        b,
+		uplinked: aprs.NewDupeDetector(*dupeWindow, 100),
+
+		disconnectSlow: *isSlowPolicy == "disconnect",
+	}
+
+	if reason := isLimits.acquire(conn.RemoteAddr()); reason != "" {
+		log.Printf("Refusing APRS-IS connection from %v: %v", conn.RemoteAddr(), reason)
+		c.comment("%v, try again later", reason)
+		return
+	}
+	defer isLimits.release(conn.RemoteAddr())
+
+	if err := c.comment("goaprs"); err != nil {
 		log.Printf("Error sending banner: %v", err)
+		return
 	}
+	if err := conn.SetReadDeadline(time.Now().Add(*isLoginTimeout)); err != nil {
+		log.Printf("Error setting login deadline: %v", err)
+		return
+	}
+	if err := c.readLogin(); err != nil {
+		log.Printf("Error reading login from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	c.queue = make(chan aprs.Frame, *isQueueSize)
+	defer close(c.queue)
+	isClients.add(c)
+	defer isClients.remove(c)
 
+	ch := make(chan interface{}, 100)
 	b.Register(ch)
-	defer b.Unregister(ch)
+	defer unregister(b, ch)
 
-	for m := range ch {
-		_, err = fmt.Fprintln(conn, m)
-		if err != nil {
-			log.Printf("Error on connection:  %v", err)
+	done := make(chan error, 3)
+	stop := make(chan bool)
+	defer close(stop)
+	go c.readLines(done)
+	go c.writeLines(done)
+	go c.keepalive(stop, done)
+
+	for _, msg := range history.replay(c.login.String(), time.Now()) {
+		if c.matches(msg) && !c.enqueue(msg) {
+			break
+		}
+	}
+
+	for {
+		select {
+		case m := <-ch:
+			msg := m.(aprs.Frame)
+			if !c.matches(msg) || c.uplinked.SeenAt(msg, time.Now()) {
+				continue
+			}
+			if !c.enqueue(msg) {
+				// The writer is stuck, so don't try to tell the
+				// client why, just hang up so everyone else can
+				// keep going.
+				log.Printf("Disconnecting slow client %v (sent %v, dropped %v)",
+					c.login, c.sent(), c.dropped())
+				conn.Close()
+				return
+			}
+		case err := <-done:
+			log.Printf("Disconnected %v: %v (sent %v, dropped %v)",
+				c.login, err, c.sent(), c.dropped())
 			return
 		}
 	}
@@ -95,8 +410,17 @@ func startIS(n, addr string, b broadcast.Broadcaster) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Fatal(serveIS(ln, b))
+}
+
+// serveIS handles APRS-IS clients connecting to the listener until
+// it's closed.
+func serveIS(ln net.Listener, b broadcast.Broadcaster) error {
 	for {
 		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
 		if err != nil {
 			log.Printf("Error accepting connections: %v", err)
 			continue
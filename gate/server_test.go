@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-broadcast"
+)
+
+func TestParseLogin(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp loginLine
+	}{
+		{"user KG6HWF pass 22955 vers goaprs 0.1",
+			loginLine{"KG6HWF", "22955", "goaprs 0.1", ""}},
+		{"user KG6HWF-9 pass -1 vers xastir 2.1.4 filter r/37/-122/50 -t/w",
+			loginLine{"KG6HWF-9", "-1", "xastir 2.1.4", "r/37/-122/50 -t/w"}},
+		{"user KG6HWF", loginLine{user: "KG6HWF"}},
+		{"user KG6HWF pass 1 filter p/K", loginLine{"KG6HWF", "1", "", "p/K"}},
+	}
+
+	for _, test := range tests {
+		got, err := parseLogin(test.in)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("parseLogin(%q) = %+v, want %+v", test.in, got, test.exp)
+		}
+	}
+
+	for _, in := range []string{"", "KG6HWF>APRS:>hi", "user"} {
+		if got, err := parseLogin(in); err == nil {
+			t.Errorf("Expected error parsing %q, got %+v", in, got)
+		}
+	}
+}
+
+func TestVerifyPass(t *testing.T) {
+	tests := []struct {
+		call, pass string
+		exp        bool
+	}{
+		{"KG6HWF", "22955", true},
+		{"KG6HWF-9", "22955", true},
+		{"KG6HWF", "22956", false},
+		{"KG6HWF", "-1", false},
+		{"", "29666", false},
+	}
+
+	for _, test := range tests {
+		if got := verifyPass(aprs.AddressFromString(test.call), test.pass); got != test.exp {
+			t.Errorf("verifyPass(%v, %v) = %v, want %v", test.call, test.pass, got, test.exp)
+		}
+	}
+}
+
+func expectLine(t *testing.T, r *textproto.Reader, exp string) {
+	line, err := r.ReadLine()
+	if err != nil {
+		t.Fatalf("Error reading line, expected %q: %v", exp, err)
+	}
+	if line != exp {
+		t.Fatalf("Expected %q, got %q", exp, line)
+	}
+}
+
+// isTestClient connects a client to handleIS over a pipe.
+func isTestClient(t *testing.T, b broadcast.Broadcaster) (net.Conn, *textproto.Reader) {
+	server, client := net.Pipe()
+	go handleIS(server, b)
+	r := textproto.NewReader(bufio.NewReader(client))
+	expectLine(t, r, "# goaprs")
+	return client, r
+}
+
+func TestHandleISLogin(t *testing.T) {
+	b := broadcast.NewBroadcaster(100)
+
+	client, r := isTestClient(t, b)
+	defer client.Close()
+
+	fmt.Fprintf(client, "# a comment first\r\n")
+	fmt.Fprintf(client, "user KG6HWF pass 22955 vers test 1.0 filter p/KG\r\n")
+	expectLine(t, r, "# logresp KG6HWF verified, server GOAPRS")
+	expectLine(t, r, "# filter p/KG active")
+
+	// Changing the filter mid-session also means we're registered.
+	fmt.Fprintf(client, "#filter p/KE\r\n")
+	expectLine(t, r, "# filter p/KE active")
+
+	b.Submit(aprs.ParseFrame(filterPosition))
+	b.Submit(aprs.ParseFrame(filterObject))
+	expectLine(t, r, filterObject)
+
+	fmt.Fprintf(client, "#filter x/1\r\n")
+	line, err := r.ReadLine()
+	if err != nil || !strings.HasPrefix(line, "# invalid filter") {
+		t.Fatalf("Expected invalid filter response, got %q/%v", line, err)
+	}
+}
+
+func TestHandleISUnverified(t *testing.T) {
+	b := broadcast.NewBroadcaster(100)
+
+	client, r := isTestClient(t, b)
+	defer client.Close()
+
+	fmt.Fprintf(client, "user KG6HWF pass -1 vers test 1.0\r\n")
+	expectLine(t, r, "# logresp KG6HWF unverified, server GOAPRS")
+}
+
+func TestHandleISUplink(t *testing.T) {
+	b := broadcast.NewBroadcaster(100)
+
+	watcher, wr := isTestClient(t, b)
+	defer watcher.Close()
+	fmt.Fprintf(watcher, "user N0CALL pass -1 vers test 1.0\r\n")
+	expectLine(t, wr, "# logresp N0CALL unverified, server GOAPRS")
+	fmt.Fprintf(watcher, "#filter p/KG\r\n")
+	expectLine(t, wr, "# filter p/KG active")
+
+	client, r := isTestClient(t, b)
+	defer client.Close()
+	fmt.Fprintf(client, "user KG6HWF pass 22955 vers test 1.0\r\n")
+	expectLine(t, r, "# logresp KG6HWF verified, server GOAPRS")
+
+	fmt.Fprintf(client, "KG6HWF>APRS:>uplinked\r\n")
+	expectLine(t, wr, "KG6HWF>APRS,TCPIP*,qAC,GOAPRS:>uplinked")
+
+	fmt.Fprintf(client, "KG6HWF-9>APRS,WIDE2-1,qAR,KG6HWF:>gated\r\n")
+	expectLine(t, wr, "KG6HWF-9>APRS,WIDE2-1,qAR,KG6HWF:>gated")
+
+	fmt.Fprintf(client, "not a packet\r\n")
+	expectLine(t, r, "# packet rejected: invalid packet")
+
+	fmt.Fprintf(watcher, "N0CALL>APRS:>unverified\r\n")
+	expectLine(t, wr, "# packet rejected: unverified login")
+
+	// The uplinking client doesn't get its own packets back.
+	b.Submit(aprs.ParseFrame(filterStatus))
+	expectLine(t, r, filterStatus)
+}
+
+func TestThirdPartyRF(t *testing.T) {
+	msg := aprs.ParseFrame("KG6HWF-9>APDR12,TCPIP*,qAC,T2SPAIN2::N6ACK    :hi{10")
+	got := thirdPartyRF(aprs.AddressFromString("KG6HWF"), msg).String()
+	exp := "KG6HWF>APRS,WIDE2-1:}KG6HWF-9>APDR12,TCPIP,KG6HWF*::N6ACK    :hi{10"
+	if got != exp {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestHeardList(t *testing.T) {
+	h := &heardList{heard: map[string]time.Time{}}
+	now := time.Now()
+	h.add("KG6HWF", now.Add(-2**rfHeardFor))
+	h.add("N6ACK", now)
+	if h.recently("KG6HWF", now) || !h.recently("N6ACK", now) {
+		t.Errorf("Expected only N6ACK to be recent")
+	}
+	h.expire(now.Add(-*rfHeardFor))
+	if _, ok := h.heard["KG6HWF"]; ok || len(h.heard) != 1 {
+		t.Errorf("Expected only KG6HWF to have expired, left %v", h.heard)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	frame := func(i int) aprs.Frame {
+		return aprs.ParseFrame(fmt.Sprintf("KG6HWF>APRS:>%d", i))
+	}
+
+	c := &isClient{queue: make(chan aprs.Frame, 2)}
+	for i := 0; i < 5; i++ {
+		if !c.enqueue(frame(i)) {
+			t.Fatalf("Expected drop policy to keep the client")
+		}
+	}
+	if c.dropped() != 3 {
+		t.Errorf("Expected 3 dropped, got %v", c.dropped())
+	}
+	for _, exp := range []string{">3", ">4"} {
+		if got := <-c.queue; string(got.Body) != exp {
+			t.Errorf("Expected newest frames to be kept, got %v", got)
+		}
+	}
+
+	c = &isClient{queue: make(chan aprs.Frame, 1), disconnectSlow: true}
+	if !c.enqueue(frame(0)) || c.enqueue(frame(1)) {
+		t.Errorf("Expected disconnect policy to refuse a full queue")
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	b := broadcast.NewBroadcaster(100)
+
+	// This client never reads anything after logging in.
+	slow, sr := isTestClient(t, b)
+	defer slow.Close()
+	fmt.Fprintf(slow, "user KG6HWF-1 pass -1 vers test 1.0\r\n")
+	expectLine(t, sr, "# logresp KG6HWF-1 unverified, server GOAPRS")
+
+	client, r := isTestClient(t, b)
+	defer client.Close()
+	fmt.Fprintf(client, "user KG6HWF-2 pass -1 vers test 1.0 filter b/LAST\r\n")
+	expectLine(t, r, "# logresp KG6HWF-2 unverified, server GOAPRS")
+	expectLine(t, r, "# filter b/LAST active")
+	fmt.Fprintf(client, "#filter b/LAST\r\n")
+	expectLine(t, r, "# filter b/LAST active")
+
+	for i := 0; i < *isQueueSize*2; i++ {
+		b.Submit(aprs.ParseFrame(fmt.Sprintf("KG6HWF>APRS:>%d", i)))
+	}
+	b.Submit(aprs.ParseFrame("LAST>APRS:>done"))
+	expectLine(t, r, "LAST>APRS:>done")
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	defer func(p string) { *isSlowPolicy = p }(*isSlowPolicy)
+	*isSlowPolicy = "disconnect"
+	b := broadcast.NewBroadcaster(100)
+
+	slow, sr := isTestClient(t, b)
+	defer slow.Close()
+	fmt.Fprintf(slow, "user KG6HWF-1 pass -1 vers test 1.0\r\n")
+	expectLine(t, sr, "# logresp KG6HWF-1 unverified, server GOAPRS")
+
+	client, r := isTestClient(t, b)
+	defer client.Close()
+	fmt.Fprintf(client, "user KG6HWF-2 pass -1 vers test 1.0 filter b/LAST\r\n")
+	expectLine(t, r, "# logresp KG6HWF-2 unverified, server GOAPRS")
+	expectLine(t, r, "# filter b/LAST active")
+	fmt.Fprintf(client, "#filter b/LAST\r\n")
+	expectLine(t, r, "# filter b/LAST active")
+
+	for i := 0; i < *isQueueSize*2; i++ {
+		b.Submit(aprs.ParseFrame(fmt.Sprintf("KG6HWF>APRS:>%d", i)))
+	}
+	b.Submit(aprs.ParseFrame("LAST>APRS:>done"))
+
+	// Hanging up on the stalled client mustn't wait on its writes.
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectLine(t, r, "LAST>APRS:>done")
+}
+
+type testAddr string
+
+func (a testAddr) Network() string { return "tcp" }
+func (a testAddr) String() string  { return string(a) }
+
+func TestConnLimits(t *testing.T) {
+	defer func(total, perIP int) { *isMaxClients, *isMaxPerIP = total, perIP }(*isMaxClients, *isMaxPerIP)
+	*isMaxClients, *isMaxPerIP = 3, 2
+
+	l := &connLimits{byIP: map[string]int{}}
+	a, b := testAddr("10.0.0.1:1234"), testAddr("10.0.0.2:1234")
+	for _, addr := range []net.Addr{a, a, b} {
+		if reason := l.acquire(addr); reason != "" {
+			t.Fatalf("Expected to accept %v, got %v", addr, reason)
+		}
+	}
+	if reason := l.acquire(b); reason != "server full" {
+		t.Errorf("Expected server full, got %q", reason)
+	}
+	l.release(b)
+	if reason := l.acquire(testAddr("10.0.0.1:5678")); reason != "too many connections from 10.0.0.1" {
+		t.Errorf("Expected per-address refusal, got %q", reason)
+	}
+	if reason := l.acquire(b); reason != "" {
+		t.Errorf("Expected to accept %v after release, got %v", b, reason)
+	}
+}
+
+func TestISRefused(t *testing.T) {
+	defer func(n int) { *isMaxClients = n }(*isMaxClients)
+	*isMaxClients = 0
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go handleIS(server, broadcast.NewBroadcaster(100))
+	r := textproto.NewReader(bufio.NewReader(client))
+	expectLine(t, r, "# server full, try again later")
+	if _, err := r.ReadLine(); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func TestISLoginTimeout(t *testing.T) {
+	defer func(d time.Duration) { *isLoginTimeout = d }(*isLoginTimeout)
+	*isLoginTimeout = 10 * time.Millisecond
+
+	client, r := isTestClient(t, broadcast.NewBroadcaster(100))
+	defer client.Close()
+	if _, err := r.ReadLine(); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func TestISKeepalive(t *testing.T) {
+	defer func(d time.Duration) { *isKeepalive = d }(*isKeepalive)
+	*isKeepalive = 10 * time.Millisecond
+
+	client, r := isTestClient(t, broadcast.NewBroadcaster(100))
+	defer client.Close()
+	fmt.Fprintf(client, "user KG6HWF pass -1 vers test 1.0\r\n")
+	expectLine(t, r, "# logresp KG6HWF unverified, server GOAPRS")
+
+	line, err := r.ReadLine()
+	if err != nil || !strings.HasPrefix(line, "# goaprs ") || !strings.HasSuffix(line, " GOAPRS") {
+		t.Fatalf("Expected a keepalive, got %q/%v", line, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-broadcast"
+)
+
+// stationMaxAge is how long a station's position is remembered
+// after it was last heard.
+const stationMaxAge = 24 * time.Hour
+
+type stationPosition struct {
+	pos   Point
+	heard time.Time
+}
+
+// A stationStore tracks the last known position of stations.
+type stationStore struct {
+	mu        sync.RWMutex
+	positions map[string]stationPosition
+}
+
+var stations = newStationStore()
+
+func newStationStore() *stationStore {
+	return &stationStore{positions: map[string]stationPosition{}}
+}
+
+// stationKey is how a station is known in the store: without a
+// repeated mark, and with SSID 0 (as heard on RF) the same as none
+// (as used in logins and filters).
+func stationKey(a aprs.Address) string {
+	a = a.Unrepeated()
+	if a.SSID == "0" {
+		a.SSID = ""
+	}
+	return a.String()
+}
+
+// update records the position in a frame, if it has one.
+func (s *stationStore) update(d aprs.Frame, now time.Time) {
+	d = thirdParty(d)
+	if _, isObject := objectName(d); isObject {
+		return
+	}
+	pos, err := d.Body.Position()
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.positions[stationKey(d.Source)] = stationPosition{Point{pos.Lat, pos.Lon}, now}
+}
+
+// position returns the last known position of a station.
+func (s *stationStore) position(call string) (Point, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.positions[stationKey(aprs.AddressFromString(call))]
+	return p.pos, ok
+}
+
+// expire forgets stations not heard since before the given time.
+func (s *stationStore) expire(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, p := range s.positions {
+		if p.heard.Before(before) {
+			delete(s.positions, k)
+		}
+	}
+}
+
+// framePosition is the position a frame is considered to be at:
+// its own position if it has one, otherwise its sender's last known
+// position.
+func (s *stationStore) framePosition(d aprs.Frame) (Point, bool) {
+	if pos, err := d.Body.Position(); err == nil {
+		return Point{pos.Lat, pos.Lon}, true
+	}
+	return s.position(stationKey(thirdParty(d).Source))
+}
+
+// near is true if the frame is within dist km of the station's
+// last known position.
+func (s *stationStore) near(d aprs.Frame, call string, dist float64) bool {
+	center, ok := s.position(call)
+	if !ok {
+		return false
+	}
+	p, ok := s.framePosition(d)
+	return ok && center.Distance(p) <= dist
+}
+
+func (s *stationStore) run(b broadcast.Broadcaster) {
+	ch := make(chan interface{}, 100)
+	b.Register(ch)
+	defer b.Unregister(ch)
+
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+
+	for {
+		select {
+		case msgi, ok := <-ch:
+			if !ok {
+				return
+			}
+			s.update(msgi.(aprs.Frame), time.Now())
+		case now := <-t.C:
+			s.expire(now.Add(-stationMaxAge))
+		}
+	}
+}
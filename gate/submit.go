@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-broadcast"
+)
+
+var (
+	submitHTTPAddr = flag.String("submit-http", "", "Bind address for APRS-IS HTTP packet submission (e.g. :8080)")
+	submitUDPAddr  = flag.String("submit-udp", "", "Bind address for APRS-IS UDP packet submission (e.g. :8080)")
+)
+
+// maxSubmission is the largest HTTP or UDP submission accepted.
+const maxSubmission = 2048
+
+// parseSubmission parses a login line followed by a packet, as sent
+// to the HTTP and UDP submission ports, and returns the packet ready
+// to be submitted.
+func parseSubmission(data string, udp bool) (aprs.Frame, error) {
+	var lines []string
+	for _, l := range strings.Split(data, "\n") {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) != 2 {
+		return aprs.Frame{}, errors.New("expected a login line and one packet")
+	}
+
+	l, err := parseLogin(lines[0])
+	if err != nil {
+		return aprs.Frame{}, err
+	}
+	login := aprs.AddressFromString(l.user)
+	return acceptPacket(lines[1], aprs.QConn{
+		Login:    login,
+		Verified: verifyPass(login, l.pass),
+		UDP:      udp,
+	})
+}
+
+// submitHandler accepts packets POSTed over HTTP.
+type submitHandler struct {
+	b broadcast.Broadcaster
+}
+
+func (h submitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST a login line and a packet", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSubmission))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, err := parseSubmission(string(data), false)
+	if err != nil {
+		log.Printf("Rejected HTTP submission from %v: %v", r.RemoteAddr, err)
+		code := http.StatusBadRequest
+		if err == errUnverified {
+			code = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	h.b.Submit(msg)
+	gateMessageToRF(msg)
+	fmt.Fprintf(w, "ok\n")
+}
+
+func startHTTPSubmit(addr string, b broadcast.Broadcaster) {
+	log.Fatal(http.ListenAndServe(addr, submitHandler{b}))
+}
+
+// readUDP submits packets from datagrams until the connection fails.
+func readUDP(conn net.PacketConn, b broadcast.Broadcaster) error {
+	buf := make([]byte, maxSubmission)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		msg, err := parseSubmission(string(buf[:n]), true)
+		if err != nil {
+			log.Printf("Rejected UDP submission from %v: %v", addr, err)
+			continue
+		}
+		b.Submit(msg)
+		gateMessageToRF(msg)
+	}
+}
+
+func startUDPSubmit(addr string, b broadcast.Broadcaster) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(readUDP(conn, b))
+}
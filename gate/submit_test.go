@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-aprs"
+	"github.com/dustin/go-broadcast"
+)
+
+func TestParseSubmission(t *testing.T) {
+	tests := []struct {
+		in  string
+		udp bool
+		exp string
+		err error
+	}{
+		{"user KG6HWF pass 22955 vers test 1.0\r\nKG6HWF>APRS:>hi\r\n", false,
+			"KG6HWF>APRS,TCPIP*,qAC,GOAPRS:>hi", nil},
+		{"user KG6HWF pass 22955 vers test 1.0\nKG6HWF-9>APRS:>hi", false,
+			"KG6HWF-9>APRS,qAS,KG6HWF:>hi", nil},
+		{"user KG6HWF pass 22955 vers test 1.0\nKG6HWF>APRS:>hi\n", true,
+			"KG6HWF>APRS,qAU,GOAPRS:>hi", nil},
+		{"user KG6HWF pass -1 vers test 1.0\nKG6HWF>APRS:>hi\n", false,
+			"", errUnverified},
+	}
+
+	for _, test := range tests {
+		got, err := parseSubmission(test.in, test.udp)
+		if err != test.err {
+			t.Errorf("parseSubmission(%q) error = %v, want %v", test.in, err, test.err)
+			continue
+		}
+		if err == nil && got.String() != test.exp {
+			t.Errorf("parseSubmission(%q) = %v, want %v", test.in, got, test.exp)
+		}
+	}
+
+	for _, in := range []string{"", "user KG6HWF pass 22955",
+		"user KG6HWF pass 22955\nKG6HWF>APRS:>a\nKG6HWF>APRS:>b",
+		"KG6HWF>APRS:>a\nuser KG6HWF pass 22955",
+		"user KG6HWF pass 22955\nnot a packet"} {
+		if got, err := parseSubmission(in, false); err == nil {
+			t.Errorf("Expected error parsing %q, got %v", in, got)
+		}
+	}
+}
+
+// submissionWatcher registers a channel to see submitted frames.
+func submissionWatcher(b broadcast.Broadcaster) chan interface{} {
+	ch := make(chan interface{}, 10)
+	b.Register(ch)
+	return ch
+}
+
+func expectSubmitted(t *testing.T, ch chan interface{}, exp string) {
+	select {
+	case m := <-ch:
+		if got := m.(aprs.Frame).String(); got != exp {
+			t.Fatalf("Expected %v to be submitted, got %v", exp, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for %v", exp)
+	}
+}
+
+func TestHTTPSubmit(t *testing.T) {
+	b := broadcast.NewBroadcaster(100)
+	ch := submissionWatcher(b)
+	h := submitHandler{b}
+
+	tests := []struct {
+		method, body string
+		code         int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "user KG6HWF pass -1\r\nKG6HWF>APRS:>hi\r\n", http.StatusForbidden},
+		{"POST", "user KG6HWF pass 22955\r\n", http.StatusBadRequest},
+		{"POST", "user KG6HWF pass 22955 vers test 1.0\r\nKG6HWF>APRS:>posted\r\n", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(test.method, "/", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatalf("Error creating request: %v", err)
+		}
+		h.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%v %q = %v, want %v", test.method, test.body, w.Code, test.code)
+		}
+	}
+	expectSubmitted(t, ch, "KG6HWF>APRS,TCPIP*,qAC,GOAPRS:>posted")
+}
+
+func TestUDPSubmit(t *testing.T) {
+	b := broadcast.NewBroadcaster(100)
+	ch := submissionWatcher(b)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer conn.Close()
+	go readUDP(conn, b)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("user KG6HWF pass -1\nKG6HWF>APRS:>ignored\n"))
+	client.Write([]byte("user KG6HWF pass 22955 vers test 1.0\nKG6HWF>APRS:>datagram\n"))
+	expectSubmitted(t, ch, "KG6HWF>APRS,qAU,GOAPRS:>datagram")
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/hex"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -16,6 +15,16 @@ func init() {
 	http.HandleFunc("/", sendMessage)
 }
 
+// kissFrame is the KISS data frame for transmitting a frame.
+func kissFrame(msg aprs.Frame) ax25.KISSFrame {
+	return ax25.KISSFrame{Port: *kissPort, Data: ax25.EncodeAPRSCommand(msg)}
+}
+
+// transmit sends a frame to the radio as a KISS data frame.
+func transmit(w tnc, msg aprs.Frame) error {
+	return w.WriteFrame(kissFrame(msg))
+}
+
 func sendMessage(w http.ResponseWriter, r *http.Request) {
 	src := r.FormValue("src")
 	dest := r.FormValue("dest")
@@ -26,17 +35,6 @@ func sendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if text != "" {
-		d := hex.Dumper(os.Stdout)
-		defer d.Close()
-		mw := io.MultiWriter(d, radio)
-
-		_, err := mw.Write([]byte{0xc0, 0x00})
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			log.Printf("Error writing command: %v", err)
-			return
-		}
-
 		msg := aprs.Frame{
 			Source: aprs.AddressFromString(src),
 			Dest:   aprs.AddressFromString(dest),
@@ -45,18 +43,14 @@ func sendMessage(w http.ResponseWriter, r *http.Request) {
 			Body: aprs.Info(text),
 		}
 
-		body := ax25.EncodeAPRSCommand(msg)
-		_, err = mw.Write(body)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			log.Printf("Error writing command: %v", err)
-			return
+		if b, err := kissFrame(msg).Encode(); err == nil {
+			d := hex.Dumper(os.Stdout)
+			d.Write(b)
+			d.Close()
 		}
-
-		_, err = mw.Write([]byte{0xc0})
-		if err != nil {
+		if err := transmit(radio, msg); err != nil {
 			http.Error(w, err.Error(), 500)
-			log.Printf("Error finishing command: %v", err)
+			log.Printf("Error writing command: %v", err)
 			return
 		}
 
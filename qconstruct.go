@@ -0,0 +1,268 @@
+package aprs
+
+import (
+	"errors"
+	"strings"
+)
+
+// A QConstruct is an APRS-IS q construct found in a packet path.
+//
+// q constructs describe how a packet entered APRS-IS and are
+// followed in the path by the callsign of the igate or server that
+// added them.
+type QConstruct string
+
+// The q constructs defined by the APRS-IS q algorithm.
+const (
+	QAC = QConstruct("qAC") // Verified client, sent its own packet
+	QAX = QConstruct("qAX") // Unverified client
+	QAU = QConstruct("qAU") // Submitted via UDP
+	QAR = QConstruct("qAR") // Gated from RF by a bidirectional igate
+	QAr = QConstruct("qAr") // Gated from RF, igate didn't add a q construct
+	QAO = QConstruct("qAO") // Gated from RF by a receive-only igate
+	QAo = QConstruct("qAo") // As qAO, but the igate didn't add a q construct
+	QAS = QConstruct("qAS") // Generated by a server or relayed for another station
+	QAZ = QConstruct("qAZ") // Server-client command, never forwarded
+	QAI = QConstruct("qAI") // Trace packet
+)
+
+// Origin classifies where a packet came from based on its q construct.
+type Origin int
+
+// Packet origins.
+const (
+	OriginUnknown Origin = iota
+	OriginRF
+	OriginVerifiedClient
+	OriginUnverifiedClient
+	OriginUDP
+	OriginServer
+	OriginCommand
+	OriginTrace
+)
+
+var originNames = map[Origin]string{
+	OriginUnknown:          "unknown",
+	OriginRF:               "RF",
+	OriginVerifiedClient:   "verified client",
+	OriginUnverifiedClient: "unverified client",
+	OriginUDP:              "UDP",
+	OriginServer:           "server",
+	OriginCommand:          "command",
+	OriginTrace:            "trace",
+}
+
+func (o Origin) String() string {
+	return originNames[o]
+}
+
+// ErrQLoop is returned when q processing detects a packet loop.
+var ErrQLoop = errors.New("q construct loop")
+
+// ErrQDrop is returned when q processing determines a packet must not
+// be forwarded.
+var ErrQDrop = errors.New("packet not forwardable")
+
+// IsQConstruct is true if this address is a q construct.
+func (a Address) IsQConstruct() bool {
+	return a.SSID == "" && len(a.Call) == 3 &&
+		a.Call[0] == 'q' && a.Call[1] == 'A'
+}
+
+// Origin classifies the source of a packet carrying this q construct.
+func (q QConstruct) Origin() Origin {
+	switch q {
+	case QAR, QAr, QAO, QAo:
+		return OriginRF
+	case QAC:
+		return OriginVerifiedClient
+	case QAX:
+		return OriginUnverifiedClient
+	case QAU:
+		return OriginUDP
+	case QAS:
+		return OriginServer
+	case QAZ:
+		return OriginCommand
+	case QAI:
+		return OriginTrace
+	}
+	return OriginUnknown
+}
+
+// QConstruct returns the q construct in this frame's path and its
+// index, or an index of -1 if there isn't one.
+func (d Frame) QConstruct() (QConstruct, int) {
+	for i, a := range d.Path {
+		if a.IsQConstruct() {
+			return QConstruct(a.Call), i
+		}
+	}
+	return "", -1
+}
+
+// QGate returns the igate or server that added the frame's q
+// construct, or the zero Address if there isn't one.
+func (d Frame) QGate() Address {
+	_, i := d.QConstruct()
+	if i < 0 || i+1 >= len(d.Path) {
+		return Address{}
+	}
+	return d.Path[i+1]
+}
+
+// Origin classifies where this frame entered APRS-IS.
+func (d Frame) Origin() Origin {
+	q, _ := d.QConstruct()
+	return q.Origin()
+}
+
+var noGateCalls = map[string]bool{
+	"TCPIP":  true,
+	"TCPXX":  true,
+	"NOGATE": true,
+	"RFONLY": true,
+}
+
+// IsGateable is true if an igate may forward this frame from RF to
+// APRS-IS.
+func (d Frame) IsGateable() bool {
+	if d.Body.Type() == '?' {
+		return false
+	}
+	for _, a := range d.Path {
+		if noGateCalls[strings.TrimSuffix(a.Call, "*")] || a.IsQConstruct() {
+			return false
+		}
+	}
+	return true
+}
+
+func (d Frame) withPath(path []Address) Frame {
+	d.Path = path
+	return d
+}
+
+func appendPath(p []Address, a ...Address) []Address {
+	rv := make([]Address, 0, len(p)+len(a))
+	rv = append(rv, p...)
+	return append(rv, a...)
+}
+
+// IGated returns the frame as an igate would place it on APRS-IS
+// after hearing it on RF, with a qAR (or qAO for receive-only igates)
+// construct naming the igate.  Frames that already carry a q
+// construct are returned unchanged.
+func (d Frame) IGated(igate Address, bidirectional bool) Frame {
+	if _, i := d.QConstruct(); i >= 0 {
+		return d
+	}
+	q := QAR
+	if !bidirectional {
+		q = QAO
+	}
+	return d.withPath(appendPath(d.Path, Address{Call: string(q)}, igate))
+}
+
+// QConn describes the connection a packet arrived on for server-side
+// q processing.
+type QConn struct {
+	// Login is the callsign the connection logged in with.
+	Login Address
+	// Verified is true if the login passcode was correct.
+	Verified bool
+	// ReceiveOnly is true for client connections that gate RF
+	// traffic but don't accept traffic back.
+	ReceiveOnly bool
+	// UDP is true for packets submitted over UDP.
+	UDP bool
+}
+
+// QProcess applies the APRS-IS server q algorithm to a frame that
+// arrived on the given connection of the named server.  It returns
+// the frame with its q construct added or rewritten, or ErrQLoop or
+// ErrQDrop if the frame must not be forwarded.
+func (d Frame) QProcess(server Address, c QConn) (Frame, error) {
+	_, qi := d.QConstruct()
+	fromLogin := d.Source.String() == c.Login.String()
+
+	// A path ending in ",VIACALL,I" is the pre-q way of saying the
+	// packet was gated from RF by VIACALL.
+	viaI := qi < 0 && len(d.Path) >= 2 &&
+		d.Path[len(d.Path)-1].String() == "I"
+	replaceI := func(q QConstruct) Frame {
+		p := appendPath(d.Path[:len(d.Path)-2],
+			Address{Call: string(q)}, d.Path[len(d.Path)-2])
+		return d.withPath(p)
+	}
+
+	switch {
+	case c.UDP:
+		if qi < 0 {
+			return d.withPath(appendPath(d.Path, Address{Call: string(QAU)}, server)), nil
+		}
+	case !c.Verified:
+		if !fromLogin {
+			return d, ErrQDrop
+		}
+		p := d.Path
+		if qi >= 0 {
+			p = p[:qi]
+		} else if viaI {
+			p = p[:len(p)-2]
+		}
+		return d.withPath(appendPath(p, Address{Call: string(QAX)}, server)), nil
+	case qi >= 0:
+		// Already has a q construct, check it below.
+	case viaI:
+		// It's whether the logged in station is the VIACALL that
+		// matters here, not who originated the packet.
+		fromGate := d.Path[len(d.Path)-2].String() == c.Login.String()
+		switch {
+		case c.ReceiveOnly && fromGate:
+			return replaceI(QAO), nil
+		case c.ReceiveOnly:
+			return replaceI(QAo), nil
+		case fromGate:
+			return replaceI(QAR), nil
+		}
+		return replaceI(QAr), nil
+	case fromLogin:
+		return d.withPath(appendPath(d.Path, Address{Call: string(QAC)}, server)), nil
+	case c.ReceiveOnly:
+		return d.withPath(appendPath(d.Path, Address{Call: string(QAO)}, c.Login)), nil
+	default:
+		return d.withPath(appendPath(d.Path, Address{Call: string(QAS)}, c.Login)), nil
+	}
+
+	q, qi := d.QConstruct()
+	if q == QAZ {
+		return d, ErrQDrop
+	}
+
+	// Whoever passed the packet on should be the last call after
+	// the q construct, and nobody should be in there twice.
+	after := d.Path[qi+1:]
+	loginAt := -1
+	seen := map[string]bool{}
+	for i, a := range after {
+		s := a.String()
+		if s == server.String() || seen[s] {
+			return d, ErrQLoop
+		}
+		seen[s] = true
+		if !c.UDP && s == c.Login.String() {
+			loginAt = i
+		}
+	}
+	if loginAt >= 0 && loginAt != len(after)-1 {
+		return d, ErrQLoop
+	}
+	if !c.UDP && loginAt < 0 {
+		d = d.withPath(appendPath(d.Path, c.Login))
+	}
+	if q == QAI {
+		d = d.withPath(appendPath(d.Path, server))
+	}
+	return d, nil
+}
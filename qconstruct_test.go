@@ -0,0 +1,148 @@
+package aprs
+
+import (
+	"testing"
+)
+
+func TestQConstruct(t *testing.T) {
+	tests := []struct {
+		in     string
+		q      QConstruct
+		gate   string
+		origin Origin
+	}{
+		{"A0RID-1>KC0PID-7,WIDE1,qAR,NX0R-6:=3851.38N/09908.75W_Home of KA0RID",
+			QAR, "NX0R-6", OriginRF},
+		{"K0ELR-15>APOT02,WIDE1-1,WIDE2-1,qAo,K0ELR:/102033h4133.03NX09029.49Wv204/000",
+			QAo, "K0ELR", OriginRF},
+		{MESSAGE, QAC, "T2SPAIN2", OriginVerifiedClient},
+		{"G4EUM-9>APOTC1,G4EUM*,WIDE2-2,qAS,M3SXA-10:/034135h5134.38N/00019.47W>155/023",
+			QAS, "M3SXA-10", OriginServer},
+		{"IQ3VQ>APD225,TCPIP*,qAI,IQ3VQ,THIRD:!4526.66NI01104.68E#",
+			QAI, "IQ3VQ", OriginTrace},
+		{christmasMsg, "", "", OriginUnknown},
+		{"KG6HWF>APX200,qAR:>dangling", QAR, "", OriginRF},
+	}
+
+	for _, test := range tests {
+		f := ParseFrame(test.in)
+		q, _ := f.QConstruct()
+		if q != test.q {
+			t.Errorf("QConstruct(%v) = %q, want %q", test.in, q, test.q)
+		}
+		if g := f.QGate().String(); g != test.gate {
+			t.Errorf("QGate(%v) = %q, want %q", test.in, g, test.gate)
+		}
+		if o := f.Origin(); o != test.origin {
+			t.Errorf("Origin(%v) = %v, want %v", test.in, o, test.origin)
+		}
+	}
+}
+
+func TestIsGateable(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp bool
+	}{
+		{christmasMsg, true},
+		{"KG6HWF>APX200,TCPIP*:>hi", false},
+		{"KG6HWF>APX200,WIDE1-1,NOGATE:>hi", false},
+		{"KG6HWF>APX200,RFONLY:>hi", false},
+		{"KG6HWF>APX200,WIDE2-1:?APRS?", false},
+		{SAMPLE2, false},
+	}
+
+	for _, test := range tests {
+		if got := ParseFrame(test.in).IsGateable(); got != test.exp {
+			t.Errorf("IsGateable(%v) = %v, want %v", test.in, got, test.exp)
+		}
+	}
+}
+
+func TestIGated(t *testing.T) {
+	igate := AddressFromString("KG6HWF-10")
+	f := ParseFrame(christmasMsg)
+
+	exp := "KG6HWF>APX200,WIDE1-1,WIDE2-1,qAR,KG6HWF-10:=3722.1 N/12159.1 W-Merry Christmas!"
+	if got := f.IGated(igate, true).String(); got != exp {
+		t.Errorf("IGated bidirectional = %v, want %v", got, exp)
+	}
+	exp = "KG6HWF>APX200,WIDE1-1,WIDE2-1,qAO,KG6HWF-10:=3722.1 N/12159.1 W-Merry Christmas!"
+	if got := f.IGated(igate, false).String(); got != exp {
+		t.Errorf("IGated receive-only = %v, want %v", got, exp)
+	}
+	if f.String() != christmasMsg {
+		t.Errorf("IGated modified the original frame: %v", f)
+	}
+
+	g := ParseFrame(SAMPLE2)
+	if got := g.IGated(igate, true).String(); got != SAMPLE2 {
+		t.Errorf("IGated re-gated %v as %v", SAMPLE2, got)
+	}
+}
+
+func TestQProcess(t *testing.T) {
+	server := AddressFromString("T2TEST")
+	verified := QConn{Login: AddressFromString("KG6HWF-10"), Verified: true}
+	rxonly := QConn{Login: AddressFromString("KG6HWF-10"), Verified: true, ReceiveOnly: true}
+	unverified := QConn{Login: AddressFromString("KG6HWF-10")}
+	udp := QConn{UDP: true}
+
+	tests := []struct {
+		in   string
+		conn QConn
+		exp  string
+		err  error
+	}{
+		{"KG6HWF-10>APRS,TCPIP*:>hi", verified,
+			"KG6HWF-10>APRS,TCPIP*,qAC,T2TEST:>hi", nil},
+		{"KG6HWF-10>APRS,TCPIP*:>hi", unverified,
+			"KG6HWF-10>APRS,TCPIP*,qAX,T2TEST:>hi", nil},
+		{"KG6HWF-10>APRS,TCPIP*,qAC,T2ELSE:>hi", unverified,
+			"KG6HWF-10>APRS,TCPIP*,qAX,T2TEST:>hi", nil},
+		{"KG6HWF-9>APRS,TCPIP*:>hi", unverified, "", ErrQDrop},
+		{"KG6HWF-9>APRS,WIDE2-1,qAR,KG6HWF-10:>hi", verified,
+			"KG6HWF-9>APRS,WIDE2-1,qAR,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1,KG6HWF-10,I:>hi", verified,
+			"KG6HWF-9>APRS,WIDE2-1,qAR,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1,N6ELSE,I:>hi", verified,
+			"KG6HWF-9>APRS,WIDE2-1,qAr,N6ELSE:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1,KG6HWF-10,I:>hi", rxonly,
+			"KG6HWF-9>APRS,WIDE2-1,qAO,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1,N6ELSE,I:>hi", rxonly,
+			"KG6HWF-9>APRS,WIDE2-1,qAo,N6ELSE:>hi", nil},
+		{"KG6HWF-10>APRS,WIDE2-1,KG6HWF-10,I:>hi", verified,
+			"KG6HWF-10>APRS,WIDE2-1,qAR,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1:>hi", verified,
+			"KG6HWF-9>APRS,WIDE2-1,qAS,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1:>hi", rxonly,
+			"KG6HWF-9>APRS,WIDE2-1,qAO,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS:>hi", udp,
+			"KG6HWF-9>APRS,qAU,T2TEST:>hi", nil},
+		{"KG6HWF-9>APRS,qAR,KG6HWF-10,T2TEST:>hi", verified, "", ErrQLoop},
+		{"KG6HWF-9>APRS,qAR,KG6HWF-10,T2X,T2X:>hi", verified, "", ErrQLoop},
+		{"KG6HWF-9>APRS,qAZ,KG6HWF-10:>hi", verified, "", ErrQDrop},
+		{"KG6HWF-9>APRS,WIDE2-1,qAR,N6ELSE:>hi", verified,
+			"KG6HWF-9>APRS,WIDE2-1,qAR,N6ELSE,KG6HWF-10:>hi", nil},
+		{"KG6HWF-9>APRS,WIDE2-1,qAR,N6ELSE:>hi", udp,
+			"KG6HWF-9>APRS,WIDE2-1,qAR,N6ELSE:>hi", nil},
+		{"KG6HWF-9>APRS,qAR,KG6HWF-10,N6ELSE:>hi", verified, "", ErrQLoop},
+		{"KG6HWF-9>APRS,TCPIP*,qAI,KG6HWF-9:>hi", verified,
+			"KG6HWF-9>APRS,TCPIP*,qAI,KG6HWF-9,KG6HWF-10,T2TEST:>hi", nil},
+		{"KG6HWF-9>APRS,TCPIP*,qAI,KG6HWF-9,KG6HWF-10:>hi", verified,
+			"KG6HWF-9>APRS,TCPIP*,qAI,KG6HWF-9,KG6HWF-10,T2TEST:>hi", nil},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFrame(test.in).QProcess(server, test.conn)
+		if err != test.err {
+			t.Errorf("QProcess(%v, %+v) error = %v, want %v",
+				test.in, test.conn, err, test.err)
+			continue
+		}
+		if err == nil && got.String() != test.exp {
+			t.Errorf("QProcess(%v, %+v) = %v, want %v",
+				test.in, test.conn, got, test.exp)
+		}
+	}
+}